@@ -0,0 +1,263 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+)
+
+// Upload describes the archive of the artifacts of a build.
+type Upload struct {
+	URL    string      `json:"url"`
+	Size   int64       `json:"size"`
+	SHA256 string      `json:"sha256"`
+	Files  []*Artifact `json:"files"`
+}
+
+// Artifact is a file of the archive, Path is relative to the checkout.
+type Artifact struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Uploader collects the artifacts of a build, archives them in a tar.gz
+// file, and uploads it to an S3-compatible bucket.
+type Uploader struct {
+	Cfg *Config
+
+	client *http.Client
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func New(ctx context.Context, cfg *Config) *Uploader {
+	ctx2, cancelFunc := context.WithCancel(ctx)
+
+	cfg.setDefaults()
+
+	return &Uploader{
+		Cfg: cfg,
+
+		client: &http.Client{},
+
+		ctx:        ctx2,
+		cancelFunc: cancelFunc,
+	}
+}
+
+// Run uploads the artifacts, it returns nil when no file matches.
+func (u *Uploader) Run() (*Upload, error) {
+	ctx, cancelFunc := duration.Context(u.ctx, u.Cfg.Timeout)
+	defer cancelFunc()
+
+	files, err := collect(u.Cfg.Dir, u.Cfg.Paths)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		u.Cfg.Logger.Warn().Msgf(
+			"No artifacts matching %s", strings.Join(u.Cfg.Paths, ", "),
+		)
+
+		return nil, nil
+	}
+
+	f, err := ioutil.TempFile(u.Cfg.WorkDir, "artifacts")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+
+	artifacts, err := u.archive(
+		io.MultiWriter(f, h), files,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{
+		URL:    u.objectURL(),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Files:  artifacts,
+	}
+
+	upload.Size, err = f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Cfg.Logger.Info().Msgf(
+		"Uploading %d artifacts (%d bytes) to %s",
+		len(artifacts), upload.Size, upload.URL,
+	)
+
+	err = u.put(ctx, upload, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// archive writes the tar.gz archive of files to w.
+func (u *Uploader) archive(w io.Writer, files []string) ([]*Artifact, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	artifacts := []*Artifact{}
+
+	for _, name := range files {
+		a, err := addFile(
+			tw, filepath.Join(u.Cfg.Dir, filepath.FromSlash(name)), name,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		artifacts = append(artifacts, a)
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return artifacts, gz.Close()
+}
+
+func addFile(tw *tar.Writer, p, name string) (*Artifact, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return nil, err
+	}
+
+	hdr.Name = name
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+
+	// the size in the header is the one written, even if the file grows
+	n, err := io.CopyN(
+		io.MultiWriter(tw, h), f, hdr.Size,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Artifact{
+		Path:   name,
+		Size:   n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func (u *Uploader) put(ctx context.Context, upload *Upload, body *os.File) error {
+	req, err := http.NewRequest("PUT", upload.URL, body)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = upload.Size
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Amz-Content-Sha256", upload.SHA256)
+
+	if u.Cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", string(u.Cfg.SessionToken))
+	}
+
+	sign(
+		req,
+		upload.SHA256,
+		credentials{u.Cfg.AccessKeyID, string(u.Cfg.SecretAccessKey)},
+		u.Cfg.Region,
+		"s3",
+		time.Now(),
+	)
+
+	resp, err := u.client.Do(
+		req.WithContext(ctx),
+	)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return fmt.Errorf(
+			"artifacts: upload to %s returned %s: %s",
+			upload.URL, resp.Status, strings.TrimSpace(string(msg)),
+		)
+	}
+
+	return nil
+}
+
+// objectURL is the URL of the archive, in the path style or the virtual
+// host style.
+func (u *Uploader) objectURL() string {
+	endpoint, _ := url.Parse(u.Cfg.Endpoint)
+
+	key := strings.TrimLeft(u.Cfg.Prefix+u.Cfg.Name, "/")
+
+	if u.Cfg.PathStyle {
+		endpoint.Path = strings.TrimRight(endpoint.Path, "/") +
+			"/" + u.Cfg.Bucket + "/" + key
+	} else {
+		endpoint.Host = u.Cfg.Bucket + "." + endpoint.Host
+		endpoint.Path = strings.TrimRight(endpoint.Path, "/") + "/" + key
+	}
+
+	return endpoint.String()
+}
@@ -0,0 +1,359 @@
+package artifacts
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	tmpDir string
+)
+
+func TestArtifacts(t *testing.T) {
+	testFuncs := map[string]func(t *testing.T){
+		"sign":           testSign,
+		"match":          testMatch,
+		"check":          testCheck,
+		"object url":     testObjectURL,
+		"upload":         testUpload,
+		"no match":       testNoMatch,
+		"upload failure": testUploadFailure,
+	}
+
+	for desc, f := range testFuncs {
+		setUp(t)
+		t.Run(desc, f)
+		tearDown(t)
+	}
+}
+
+// testSign checks the get-vanilla case of the AWS Signature Version 4 test
+// suite.
+func testSign(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	require.Nil(t, err)
+
+	now, err := time.Parse(sigDateFormat, "20150830T123600Z")
+	require.Nil(t, err)
+
+	sign(
+		req,
+		hashHex(nil),
+		credentials{"AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"},
+		"us-east-1",
+		"service",
+		now,
+	)
+
+	require.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, "+
+			"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"),
+	)
+}
+
+func testMatch(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"bin/app", "bin/app", true},
+		{"bin/*", "bin/app", true},
+		{"bin/*", "bin/sub/app", true},
+		{"bin", "bin/sub/app", true},
+		{"*.xml", "reports/junit.xml", false},
+		{"**/*.xml", "reports/junit.xml", true},
+		{"**/*.xml", "junit.xml", true},
+		{"reports/**/junit.xml", "reports/a/b/junit.xml", true},
+		{"reports/**", "reports/a/junit.xml", true},
+		{"reports/**", "other/junit.xml", false},
+		{"bin/app", "bin/application", false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t,
+			tc.matches, matchAny([]string{tc.pattern}, tc.name),
+			tc.pattern+" "+tc.name,
+		)
+	}
+}
+
+func testCheck(t *testing.T) {
+	valid := &Config{
+		Paths:           []string{"bin/*", "**/*.xml"},
+		Bucket:          "builds",
+		AccessKeyID:     "key",
+		SecretAccessKey: "s3cr3t",
+	}
+
+	require.Nil(t, valid.Check())
+	require.Nil(t, (&Config{}).Check())
+
+	invalid := map[string]func(c *Config){
+		"artifacts.paths":         func(c *Config) { c.Paths = []string{"../etc"} },
+		"artifacts.paths ":        func(c *Config) { c.Paths = []string{"/etc"} },
+		"artifacts.paths  ":       func(c *Config) { c.Paths = []string{"[a"} },
+		"artifacts.bucket":        func(c *Config) { c.Bucket = "" },
+		"artifacts.endpoint":      func(c *Config) { c.Endpoint = "minio:9000" },
+		"artifacts.access_key_id": func(c *Config) { c.SecretAccessKey = "" },
+	}
+
+	for field, f := range invalid {
+		c := *valid
+		f(&c)
+
+		err := c.Check()
+		require.NotNil(t, err, field)
+		require.Contains(t, err.Error(), strings.TrimSpace(field))
+	}
+
+	// ---
+
+	buff, err := json.Marshal(valid)
+	require.Nil(t, err)
+	require.NotContains(t, string(buff), "s3cr3t")
+}
+
+func testObjectURL(t *testing.T) {
+	u := New(context.Background(), &Config{
+		Region: "eu-west-1",
+		Bucket: "builds",
+		Prefix: "web/42/",
+		Name:   "artifacts.tar.gz",
+	})
+
+	require.Equal(t,
+		"https://builds.s3.eu-west-1.amazonaws.com/web/42/artifacts.tar.gz",
+		u.objectURL(),
+	)
+
+	u.Cfg.Endpoint = "http://localhost:9000"
+	u.Cfg.PathStyle = true
+
+	require.Equal(t,
+		"http://localhost:9000/builds/web/42/artifacts.tar.gz",
+		u.objectURL(),
+	)
+}
+
+func testUpload(t *testing.T) {
+	dir := writeCheckout(t)
+
+	s3 := newFakeS3(t)
+	defer s3.Close()
+
+	u := newUploader(s3.URL, dir, []string{"bin", "**/*.xml"})
+
+	upload, err := u.Run()
+	require.Nil(t, err)
+	require.NotNil(t, upload)
+
+	require.Equal(t, s3.URL+"/builds/web/42/artifacts.tar.gz", upload.URL)
+
+	object := s3.objects["/builds/web/42/artifacts.tar.gz"]
+	require.Equal(t, int64(len(object)), upload.Size)
+	require.Equal(t, hashHex(object), upload.SHA256)
+
+	paths := []string{}
+	for _, a := range upload.Files {
+		paths = append(paths, a.Path)
+	}
+
+	require.Equal(t,
+		[]string{"bin/app", "bin/tools/lint", "reports/unit/junit.xml"},
+		paths,
+	)
+
+	// the archive holds the files described
+	files := readArchive(t, object)
+
+	for _, a := range upload.Files {
+		contents, ok := files[a.Path]
+		require.True(t, ok, a.Path)
+
+		sum := sha256.Sum256(contents)
+		require.Equal(t, hex.EncodeToString(sum[:]), a.SHA256)
+		require.Equal(t, int64(len(contents)), a.Size)
+	}
+
+	// the temporary archive is removed
+	left, err := filepath.Glob(filepath.Join(tmpDir, "artifacts*"))
+	require.Nil(t, err)
+	require.Empty(t, left)
+}
+
+func testNoMatch(t *testing.T) {
+	dir := writeCheckout(t)
+
+	u := newUploader("http://localhost:1", dir, []string{"dist/*"})
+
+	upload, err := u.Run()
+	require.Nil(t, err)
+	require.Nil(t, upload)
+}
+
+func testUploadFailure(t *testing.T) {
+	dir := writeCheckout(t)
+
+	srv := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<Error><Code>AccessDenied</Code></Error>"))
+		}),
+	)
+	defer srv.Close()
+
+	u := newUploader(srv.URL, dir, []string{"bin"})
+
+	_, err := u.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "AccessDenied")
+}
+
+func setUp(t *testing.T) {
+	d, err := ioutil.TempDir(
+		"", "artifactstestsuite",
+	)
+
+	require.Nil(t, err)
+
+	tmpDir = d
+}
+
+func tearDown(t *testing.T) {
+	err := os.RemoveAll(tmpDir)
+	require.Nil(t, err)
+}
+
+func newUploader(endpoint, dir string, paths []string) *Uploader {
+	return New(context.Background(), &Config{
+		Paths: paths,
+
+		Endpoint:  endpoint,
+		Bucket:    "builds",
+		PathStyle: true,
+		Prefix:    "web/42/",
+		Name:      "artifacts.tar.gz",
+
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio-secret",
+
+		Dir:     dir,
+		WorkDir: tmpDir,
+		Logger:  zerolog.Nop(),
+	})
+}
+
+// writeCheckout writes a build result in tmpDir.
+func writeCheckout(t *testing.T) string {
+	dir := filepath.Join(tmpDir, "checkout")
+
+	files := map[string]string{
+		"bin/app":                "binary",
+		"bin/tools/lint":         "another binary",
+		"reports/unit/junit.xml": "<testsuite/>",
+		"main.go":                "package main\n",
+		".git/config":            "[core]\n",
+	}
+
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+
+		require.Nil(t, os.MkdirAll(filepath.Dir(p), 0700))
+		require.Nil(t, ioutil.WriteFile(p, []byte(contents), 0600))
+	}
+
+	// not followed
+	require.Nil(t, os.Symlink("/etc/passwd", filepath.Join(dir, "bin", "passwd")))
+
+	return dir
+}
+
+func readArchive(t *testing.T, buff []byte) map[string][]byte {
+	gz, err := gzip.NewReader(bytes.NewReader(buff))
+	require.Nil(t, err)
+
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+
+		contents, err := ioutil.ReadAll(tr)
+		require.Nil(t, err)
+
+		files[hdr.Name] = contents
+	}
+
+	return files
+}
+
+// fakeS3 stands in for an S3-compatible storage such as MinIO, checking
+// the signature and the payload hash of the objects it is sent.
+type fakeS3 struct {
+	*httptest.Server
+
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	s3 := &fakeS3{
+		objects: map[string][]byte{},
+	}
+
+	s3.Server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.Nil(t, err)
+
+			require.Equal(t, "PUT", r.Method)
+			require.Equal(t, hashHex(body), r.Header.Get("X-Amz-Content-Sha256"))
+
+			now, err := time.Parse(sigDateFormat, r.Header.Get("X-Amz-Date"))
+			require.Nil(t, err)
+
+			req, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+			require.Nil(t, err)
+
+			for k, v := range r.Header {
+				if k != "Authorization" {
+					req.Header[k] = v
+				}
+			}
+
+			sign(req, hashHex(body), credentials{"minio", "minio-secret"}, defaultRegion, "s3", now)
+
+			if req.Header.Get("Authorization") != r.Header.Get("Authorization") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			s3.objects[r.URL.Path] = body
+		}),
+	)
+
+	return s3
+}
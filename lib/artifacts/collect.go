@@ -0,0 +1,95 @@
+package artifacts
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// collect returns the regular files of dir matching one of the patterns,
+// relative to dir and sorted. Symbolic links are left out, they could lead
+// out of the checkout, as well as the .git directory.
+func collect(dir string, patterns []string) ([]string, error) {
+	files := []string{}
+
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		if fi.IsDir() && rel == ".git" {
+			return filepath.SkipDir
+		}
+
+		if !fi.Mode().IsRegular() || !matchAny(patterns, rel) {
+			return nil
+		}
+
+		files = append(files, rel)
+
+		return nil
+	})
+
+	sort.Strings(files)
+
+	return files, err
+}
+
+// matchAny tells whether name, or one of its parent directories, matches
+// one of the patterns.
+func matchAny(patterns []string, name string) bool {
+	parts := strings.Split(name, "/")
+
+	for _, pattern := range patterns {
+		pp := strings.Split(
+			strings.Trim(pattern, "/"), "/",
+		)
+
+		for i := 1; i <= len(parts); i++ {
+			if match(pp, parts[:i]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// match is path.Match, ** matching any number of path elements.
+func match(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+
+			for i := 0; i <= len(name); i++ {
+				if match(pattern, name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,123 @@
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/redact"
+)
+
+const (
+	defaultRegion = "us-east-1"
+)
+
+type Config struct {
+	// Glob patterns of the files collected, relative to the checkout, **
+	// matching any number of directories. Directories are collected with
+	// their contents.
+	Paths []string `json:"paths"`
+
+	// Collect the artifacts of failed build scripts as well
+	OnFailure bool `json:"on_failure"`
+
+	// S3-compatible storage, AWS S3 unless Endpoint is set. PathStyle puts
+	// the bucket in the path rather than in the host name, as MinIO
+	// expects.
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	PathStyle bool   `json:"path_style"`
+
+	// The archive is uploaded as Prefix + Name, Name defaulting to
+	// artifacts-<time>.tar.gz
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+
+	AccessKeyID     string        `json:"access_key_id"`
+	SecretAccessKey redact.Secret `json:"secret_access_key"`
+	SessionToken    redact.Secret `json:"session_token"`
+
+	Timeout duration.Duration `json:"timeout"`
+
+	Dir     string `json:"-"`
+	WorkDir string `json:"-"`
+
+	Logger zerolog.Logger `json:"-"`
+}
+
+func (c *Config) Enabled() bool {
+	return len(c.Paths) > 0
+}
+
+// Check makes sure the patterns stay within the checkout and the storage
+// is set, when artifacts are enabled.
+func (c *Config) Check() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	for _, p := range c.Paths {
+		err := checkPattern(p)
+		if err != nil {
+			return fmt.Errorf("artifacts.paths: %s", err)
+		}
+	}
+
+	if c.Bucket == "" {
+		return errors.New("artifacts.bucket: required")
+	}
+
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("artifacts.endpoint: %q is not an HTTP URL", c.Endpoint)
+		}
+	}
+
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return errors.New("artifacts.access_key_id: required along with artifacts.secret_access_key")
+	}
+
+	return nil
+}
+
+func (c *Config) setDefaults() {
+	if c.Region == "" {
+		c.Region = defaultRegion
+	}
+
+	if c.Endpoint == "" {
+		c.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	}
+
+	if c.Name == "" {
+		c.Name = fmt.Sprintf(
+			"artifacts-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"),
+		)
+	}
+}
+
+func checkPattern(p string) error {
+	if p == "" || strings.HasPrefix(p, "/") {
+		return fmt.Errorf("%q is not relative to the checkout", p)
+	}
+
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return fmt.Errorf("%q is not within the checkout", p)
+		}
+
+		_, err := path.Match(part, "")
+		if err != nil {
+			return fmt.Errorf("%q: %s", p, err)
+		}
+	}
+
+	return nil
+}
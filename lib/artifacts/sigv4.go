@@ -0,0 +1,167 @@
+package artifacts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, see
+// https://docs.aws.amazon.com/general/latest/gr/signature-version-4.html
+
+const (
+	sigAlgorithm  = "AWS4-HMAC-SHA256"
+	sigDateFormat = "20060102T150405Z"
+)
+
+type credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// sign adds the Authorization header to req, signing its host and x-amz-*
+// headers. payloadHash is the hex encoded SHA-256 of the body.
+func sign(req *http.Request, payloadHash string, creds credentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(sigDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	canonicalHeaders, signedHeaders := canonicalHeaders(req)
+
+	uri := req.URL.Path
+	if uri == "" {
+		uri = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(uri, false),
+		canonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{
+		amzDate[:8], region, service, "aws4_request",
+	}, "/")
+
+	stringToSign := strings.Join([]string{
+		sigAlgorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, v := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+
+	signature := hex.EncodeToString(
+		hmacSHA256(key, stringToSign),
+	)
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigAlgorithm, creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{
+		"host": host,
+	}
+
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+
+		if strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := []string{}
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	buff := ""
+	for _, k := range names {
+		buff += k + ":" + headers[k] + "\n"
+	}
+
+	return buff, strings.Join(names, ";")
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	params := []string{}
+
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+
+		for _, v := range values {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters of
+// RFC 3986, and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	buff := strings.Builder{}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buff.WriteByte(c)
+
+		case c == '/' && !encodeSlash:
+			buff.WriteByte(c)
+
+		default:
+			fmt.Fprintf(&buff, "%%%02X", c)
+		}
+	}
+
+	return buff.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
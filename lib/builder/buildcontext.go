@@ -0,0 +1,53 @@
+package builder
+
+import (
+	"fmt"
+)
+
+// BuildContext tells which SquareScale project and environment a build
+// belongs to, so that the receivers of the callbacks can route its
+// results.
+type BuildContext struct {
+	Project     string `json:"project,omitempty"`
+	ProjectUUID string `json:"project_uuid,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+// fillFromEnv completes the values left out of the job with those of the
+// Nomad task.
+func (c *BuildContext) fillFromEnv(getenv func(string) string) {
+	fields := []struct {
+		value *string
+		name  string
+	}{
+		{&c.Project, "SQSC_PROJECT"},
+		{&c.ProjectUUID, "SQSC_PROJECT_UUID"},
+		{&c.Environment, "SQSC_ENVIRONMENT"},
+	}
+
+	for _, f := range fields {
+		if *f.value == "" {
+			*f.value = getenv(f.name)
+		}
+	}
+}
+
+// contextEnv is the allow-list of the build context variables exported to
+// the build script, the other SQSC_* variables of the task are not.
+func contextEnv(c *BuildContext) []string {
+	vars := [][2]string{
+		{"SQSC_PROJECT", c.Project},
+		{"SQSC_PROJECT_UUID", c.ProjectUUID},
+		{"SQSC_ENVIRONMENT", c.Environment},
+	}
+
+	buff := []string{}
+
+	for _, v := range vars {
+		buff = append(
+			buff, fmt.Sprintf("%s=%s", v[0], v[1]),
+		)
+	}
+
+	return buff
+}
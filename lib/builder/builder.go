@@ -1,19 +1,27 @@
 package builder
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"net/http"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hpcloud/tail"
+	"github.com/squarescale/simple-builder/lib/artifacts"
+	"github.com/squarescale/simple-builder/lib/duration"
 	"github.com/squarescale/simple-builder/lib/gitcloner"
+	"github.com/squarescale/simple-builder/lib/logstream"
+	"github.com/squarescale/simple-builder/lib/notifier"
+	"github.com/squarescale/simple-builder/lib/redact"
 	"github.com/squarescale/simple-builder/lib/scriptrunner"
+	"github.com/squarescale/simple-builder/lib/source"
 	"github.com/squarescale/simple-builder/lib/version"
 
 	"github.com/rs/zerolog"
@@ -22,18 +30,40 @@ import (
 type Builder struct {
 	Cfg *Config
 
+	Event  string          `json:"event"`
+	Status Status          `json:"status"`
+	Events []*Event        `json:"events"`
 	Errors []*BuilderError `json:"errors"`
 	Output string          `json:"output"`
 
-	// XXX: there is no data available for JSON marshalling in os.ProcessState
+	ErrorCategory ErrorCategory `json:"error_category,omitempty"`
+
+	Context *BuildContext `json:"context"`
+
+	Git    *gitcloner.CommitInfo `json:"git,omitempty"`
+	Clone  *ProcessInfo          `json:"clone,omitempty"`
+	Script *ProcessInfo          `json:"script,omitempty"`
+
+	Artifacts *artifacts.Upload `json:"artifacts,omitempty"`
+
+	// XXX: there is no data available for JSON marshalling in
+	// os.ProcessState, see Clone and Script instead
 	ProcessState *os.ProcessState `json:"-"`
 
+	// set when the final payload could not be delivered
+	callbackErr error
+
 	workDir string
 	logFile *os.File
 	logger  zerolog.Logger
+	masker  *redact.Masker
 
-	cloner *gitcloner.Cloner
-	runner *scriptrunner.Runner
+	source   source.Source
+	cloner   *gitcloner.Cloner
+	runner   *scriptrunner.Runner
+	uploader *artifacts.Uploader
+	notifier *notifier.Notifier
+	streamer *logstream.Streamer
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
@@ -47,26 +77,47 @@ func New(ctx context.Context, cfgFile string) (*Builder, error) {
 		return nil, err
 	}
 
-	ctx2, cancelFunc := context.WithCancel(ctx)
+	// the process runs this job only, its variables describe the context
+	cfg.setDefaults()
+	cfg.Context.fillFromEnv(os.Getenv)
 
-	wd, err := initWorkDir()
+	return NewFromConfig(ctx, cfg)
+}
+
+// NewFromConfig prepares the build of a job decoded by NewConfig, or built
+// by hand, in which case missing components get their defaults.
+func NewFromConfig(ctx context.Context, cfg *Config) (*Builder, error) {
+	err := CheckConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	lf, err := initLogFile(wd)
+	masker, err := initMasker(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	logger := zerolog.New(lf).With().Timestamp().Logger()
+	wd, err := initWorkDir()
+	if err != nil {
+		return nil, &internalError{err}
+	}
+
+	lf, err := initLogFile(wd)
+	if err != nil {
+		os.RemoveAll(wd)
+		return nil, &internalError{err}
+	}
+
+	ctx2, cancelFunc := buildContext(ctx, cfg)
 
 	b := &Builder{
 		Cfg: cfg,
 
+		Context: cfg.Context,
+
 		workDir: wd,
 		logFile: lf,
-		logger:  logger,
+		masker:  masker,
 
 		ctx:        ctx2,
 		cancelFunc: cancelFunc,
@@ -74,41 +125,157 @@ func New(ctx context.Context, cfgFile string) (*Builder, error) {
 
 	// ---
 
-	b.initGitCloner()
+	b.initNotifier()
+
+	b.initLogger()
+
+	b.initSource()
 
 	b.initScriptRunner()
 
+	b.initArtifacts()
+
+	b.emit(EventBuildQueued, time.Time{}, "")
+
 	return b, nil
 }
 
 func (b *Builder) Run() error {
 	b.logBuildInfo()
 
+	if b.streamer != nil {
+		b.streamer.Start()
+	}
+
+	start := time.Now()
+	b.emit(EventBuildStarted, time.Time{}, "")
+
 	defer func() {
+		b.Event = EventBuildFinished
+		b.emit(EventBuildFinished, start, b.Status)
+
+		if b.streamer != nil {
+			b.streamer.Close()
+		}
+
 		b.logFile.Close()
 		b.fetchBuildOutput()
-		b.notifyCallbacks()
+
+		err := b.notifyCallbacks()
+		if err != nil {
+			log.Printf("Callbacks: %s", err)
+			b.callbackErr = err
+		}
 	}()
 
 	go b.tailBuildOutput(b.ctx)
 
-	err := b.cloner.Run()
+	cloneStart := time.Now()
+	b.emit(EventCloneStarted, time.Time{}, "")
+
+	err := b.source.Run()
+
+	if b.cloner != nil {
+		b.Clone = newProcessInfo(
+			b.cloner.ProcessState,
+			time.Since(cloneStart),
+			b.cloner.Termination,
+		)
+		b.setStatus(err, b.cloner.ProcessState, StatusCloneFailed)
+	} else {
+		b.Status = taskStatus(err, StatusCloneFailed)
+	}
+
+	b.emit(EventCloneFinished, cloneStart, b.Status)
+
 	if err != nil {
 		b.appendError(err)
-		b.setProcessState(b.cloner.ProcessState)
+		b.ErrorCategory = errorCategory(err)
+
+		if b.cloner != nil {
+			b.setProcessState(b.cloner.ProcessState)
+		}
+
 		return err
 	}
 
+	if b.cloner != nil {
+		b.useGitCheckout()
+	}
+
+	scriptStart := time.Now()
+	b.emit(EventScriptStarted, time.Time{}, "")
+
 	err = b.runner.Run()
+	b.Script = newProcessInfo(
+		b.runner.ProcessState,
+		time.Since(scriptStart),
+		b.runner.Termination,
+	)
+	b.setStatus(err, b.runner.ProcessState, StatusScriptFailed)
+	b.emit(EventScriptFinished, scriptStart, b.Status)
+
 	if err != nil {
 		b.appendError(err)
 		b.setProcessState(b.runner.ProcessState)
 	}
 
+	if b.uploader != nil && (err == nil || b.Cfg.Artifacts.OnFailure && b.Status == StatusScriptFailed) {
+		uploadErr := b.uploadArtifacts()
+
+		// the failure of the script comes first
+		if err == nil {
+			err = uploadErr
+		}
+	}
+
 	return err
 }
 
+func (b *Builder) uploadArtifacts() error {
+	start := time.Now()
+	b.emit(EventArtifactsStarted, time.Time{}, "")
+
+	upload, err := b.uploader.Run()
+	b.Artifacts = upload
+
+	status := taskStatus(err, StatusArtifactsFailed)
+	b.emit(EventArtifactsFinished, start, status)
+
+	if err != nil {
+		b.appendError(err)
+
+		if b.Status == StatusSuccess {
+			b.Status = status
+		}
+	}
+
+	return err
+}
+
+// useGitCheckout exposes the cloned commit to the payload and the script.
+func (b *Builder) useGitCheckout() {
+	b.Git = b.cloner.Info
+
+	b.runner.Cfg.ExtraEnv = append(
+		b.runner.Cfg.ExtraEnv, gitEnv(b.Git)...,
+	)
+
+	if b.Cfg.GitCloner.SSHAgentForward && b.cloner.AuthSock() != "" {
+		b.runner.Cfg.ExtraEnv = append(
+			b.runner.Cfg.ExtraEnv, "SSH_AUTH_SOCK="+b.cloner.AuthSock(),
+		)
+	}
+}
+
+// LogPath returns the build log file, which is removed by Cleanup, b.Output
+// holds its contents once the build is finished.
+func (b *Builder) LogPath() string {
+	return b.logFile.Name()
+}
+
 func (b *Builder) Cleanup() {
+	b.source.Cleanup()
 	os.RemoveAll(b.workDir)
 }
 
@@ -116,15 +283,88 @@ func (b *Builder) setProcessState(s *os.ProcessState) {
 	b.ProcessState = s
 }
 
+func (b *Builder) setStatus(err error, s *os.ProcessState, failed Status) {
+	b.Status = phaseStatus(err, s, failed)
+}
+
+func (b *Builder) initLogger() {
+	var w io.Writer = b.logFile
+
+	if len(b.Cfg.LogStream.URLs) > 0 {
+		b.streamer = logstream.New(
+			b.Cfg.LogStream, b.notifier,
+		)
+
+		w = io.MultiWriter(w, b.streamer)
+	}
+
+	// zerolog writes whole lines, nothing is ever held back here
+	w = redact.NewWriter(w, b.masker)
+
+	l := zerolog.New(w).With().Timestamp()
+
+	c := b.Context
+
+	if c.Project != "" {
+		l = l.Str("project", c.Project)
+	}
+
+	if c.ProjectUUID != "" {
+		l = l.Str("project_uuid", c.ProjectUUID)
+	}
+
+	if c.Environment != "" {
+		l = l.Str("environment", c.Environment)
+	}
+
+	b.logger = l.Logger()
+}
+
+func (b *Builder) initSource() {
+	cfg := b.Cfg.Source
+
+	srcCfg := &source.Config{
+		Type:            cfg.Type,
+		URL:             cfg.URL,
+		SHA256:          cfg.SHA256,
+		StripComponents: cfg.StripComponents,
+		Path:            cfg.Path,
+
+		Timeout: cfg.Timeout,
+
+		Dir:     filepath.Join(b.workDir, "source"),
+		WorkDir: b.workDir,
+
+		Logger: b.logger,
+	}
+
+	switch cfg.Type {
+	case source.TypeArchive:
+		b.source = source.NewArchive(b.ctx, srcCfg)
+
+	case source.TypeLocal:
+		b.source = source.NewLocal(b.ctx, srcCfg)
+
+	default:
+		b.initGitCloner()
+		b.source = b.cloner
+	}
+}
+
 func (b *Builder) initGitCloner() {
-	// XXX: forced to keep buggy legacy behavior
-	b.Cfg.GitCloner.Recursive = true
+	// XXX: forced to keep buggy legacy behavior, git_recursive is only
+	// honored from schema version 2 on
+	if b.Cfg.SchemaVersion < 2 {
+		b.Cfg.GitCloner.Recursive = true
+	}
 
 	cfg := b.Cfg.GitCloner
 
 	b.cloner = gitcloner.New(b.ctx, &gitcloner.Config{
 		RepoURL:     cfg.RepoURL,
 		Branch:      cfg.Branch,
+		Ref:         cfg.Ref,
+		Commit:      cfg.Commit,
 		CheckoutDir: cfg.CheckoutDir,
 
 		SSHKeyContents: cfg.SSHKeyContents,
@@ -132,14 +372,42 @@ func (b *Builder) initGitCloner() {
 		SSHKeyDir: filepath.Join(
 			b.workDir, ".ssh",
 		),
+		KnownHosts: cfg.KnownHosts,
+
+		AcceptNewHostKeys: cfg.AcceptNewHostKeys,
+
+		SSHKeyPassphrase: cfg.SSHKeyPassphrase,
+		SSHAgent:         cfg.SSHAgent,
+		SSHAgentForward:  cfg.SSHAgentForward,
+
+		Username: cfg.Username,
+		Token:    cfg.Token,
 
 		FullClone: cfg.FullClone,
 		Recursive: cfg.Recursive,
 
+		SubmodulePaths:       cfg.SubmodulePaths,
+		SubmoduleDepth:       cfg.SubmoduleDepth,
+		SubmoduleCredentials: cfg.SubmoduleCredentials,
+
+		CacheDir:      cfg.CacheDir,
+		CacheMaxBytes: cfg.CacheMaxBytes,
+
+		SparsePaths: cfg.SparsePaths,
+		Filter:      cfg.Filter,
+
+		LFS:        cfg.LFS,
+		LFSInclude: cfg.LFSInclude,
+		LFSExclude: cfg.LFSExclude,
+
+		Timeout:         cfg.Timeout,
+		KillGracePeriod: b.Cfg.KillGracePeriod.Duration,
+
 		WorkDir:  b.workDir,
 		ExtraEnv: commonEnv(b.workDir),
 
 		Logger: b.logger,
+		Masker: b.masker,
 	})
 }
 
@@ -152,14 +420,67 @@ func (b *Builder) initScriptRunner() {
 			b.workDir, "build",
 		),
 
-		ExtraEnv: commonEnv(b.workDir),
-		Logger:   b.logger,
+		ExtraEnv: append(
+			commonEnv(b.workDir), contextEnv(b.Context)...,
+		),
+		Logger: b.logger,
+		Masker: b.masker,
+
+		Env:       cfg.Env,
+		SecretEnv: cfg.SecretEnv,
+
+		Timeout:         cfg.Timeout,
+		KillGracePeriod: b.Cfg.KillGracePeriod.Duration,
+
+		//XXX: because the script must be executed at the root of the git repo,
+		// or one of its subdirectories
+		Subdir: cfg.Subdir,
+		WorkDir: filepath.Join(
+			b.source.Dir(), cfg.Subdir,
+		),
+	})
+}
+
+func (b *Builder) initArtifacts() {
+	cfg := b.Cfg.Artifacts
+
+	if !cfg.Enabled() {
+		return
+	}
+
+	b.uploader = artifacts.New(b.ctx, &artifacts.Config{
+		Paths:     cfg.Paths,
+		OnFailure: cfg.OnFailure,
 
-		//XXX: because the script must be executed at the root of the git repo
-		WorkDir: b.cloner.Cfg.CheckoutDir,
+		Endpoint:  cfg.Endpoint,
+		Region:    cfg.Region,
+		Bucket:    cfg.Bucket,
+		PathStyle: cfg.PathStyle,
+
+		Prefix: cfg.Prefix,
+		Name:   cfg.Name,
+
+		AccessKeyID:     cfg.AccessKeyID,
+		SecretAccessKey: cfg.SecretAccessKey,
+		SessionToken:    cfg.SessionToken,
+
+		Timeout: cfg.Timeout,
+
+		Dir:     b.source.Dir(),
+		WorkDir: b.workDir,
+
+		Logger: b.logger,
 	})
 }
 
+func (b *Builder) initNotifier() {
+	// XXX: not bound to b.ctx, callbacks must be sent even once the build
+	// has been cancelled
+	b.notifier = notifier.New(
+		context.Background(), b.Cfg.Notifier,
+	)
+}
+
 func (b *Builder) fetchBuildOutput() {
 	bytes, err := ioutil.ReadFile(
 		b.logFile.Name(),
@@ -173,6 +494,12 @@ func (b *Builder) fetchBuildOutput() {
 }
 
 func (b *Builder) notifyCallbacks() error {
+	// payloads left over by previous builds go first
+	err := b.notifier.Replay()
+	if err != nil {
+		log.Printf("Callbacks outbox: %s", err)
+	}
+
 	if len(b.Cfg.Callbacks) == 0 {
 		return nil
 	}
@@ -182,19 +509,12 @@ func (b *Builder) notifyCallbacks() error {
 		return err
 	}
 
-	for _, cb := range b.Cfg.Callbacks {
-		_, err := http.Post(
-			cb,
-			"application/json",
-			bytes.NewBuffer(data),
-		)
+	// the job configuration is part of the payload
+	data = b.masker.Mask(data)
 
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return b.notifier.Notify(
+		b.Cfg.Callbacks, data,
+	)
 }
 
 func (b *Builder) appendError(e error) {
@@ -250,6 +570,109 @@ func (b *Builder) logBuildInfo() {
 
 // ---
 
+// buildContext bounds the whole build, clone and script included, to
+// build_timeout.
+func buildContext(ctx context.Context, cfg *Config) (context.Context, context.CancelFunc) {
+	return duration.Context(ctx, cfg.BuildTimeout)
+}
+
+// CheckConfig returns the first problem NewFromConfig would find in a job,
+// without preparing its build. Missing components get their defaults.
+func CheckConfig(cfg *Config) error {
+	cfg.setDefaults()
+
+	err := cfg.GitCloner.StripURLCredentials()
+	if err != nil {
+		return err
+	}
+
+	err = checkConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = initMasker(cfg)
+
+	return err
+}
+
+func checkConfig(cfg *Config) error {
+	err := cfg.Source.Check()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.ScriptRunner.CheckSubdir()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Artifacts.Check()
+	if err != nil {
+		return err
+	}
+
+	if cfg.ScriptRunner.StrictEnv {
+		err = cfg.ScriptRunner.CheckEnvReferences(declaredEnv(cfg))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// declaredEnv lists the variables exported to the build script on top of
+// the job env.
+func declaredEnv(cfg *Config) []string {
+	declared := append(
+		envNames(commonEnv("")),
+		envNames(contextEnv(&BuildContext{}))...,
+	)
+
+	if cfg.Source.Type == source.TypeGit {
+		declared = append(
+			declared, envNames(gitEnv(&gitcloner.CommitInfo{}))...,
+		)
+	}
+
+	if cfg.GitCloner.SSHAgentForward {
+		declared = append(declared, "SSH_AUTH_SOCK")
+	}
+
+	return declared
+}
+
+// initMasker prepares the masking of every value declared as secret in the
+// job, in the logs as well as in the callback payloads.
+func initMasker(cfg *Config) (*redact.Masker, error) {
+	m, err := redact.NewMasker(cfg.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	m.AddSecrets(cfg.Secrets...)
+
+	m.AddSecrets(
+		cfg.GitCloner.SSHKeyContents,
+		cfg.GitCloner.SSHKeyPassphrase,
+		cfg.GitCloner.Token,
+		string(cfg.Notifier.Secret),
+		string(cfg.Artifacts.SecretAccessKey),
+		string(cfg.Artifacts.SessionToken),
+	)
+
+	for _, v := range cfg.ScriptRunner.SecretEnv {
+		m.AddSecrets(v)
+	}
+
+	for _, sc := range cfg.GitCloner.SubmoduleCredentials {
+		m.AddSecrets(sc.Token)
+	}
+
+	return m, nil
+}
+
 func initWorkDir() (string, error) {
 	tmp, err := ioutil.TempDir(
 		"", "simple-builder",
@@ -304,3 +727,15 @@ func commonEnv(home string) []string {
 
 	return buff
 }
+
+func envNames(env []string) []string {
+	names := []string{}
+
+	for _, e := range env {
+		names = append(
+			names, strings.SplitN(e, "=", 2)[0],
+		)
+	}
+
+	return names
+}
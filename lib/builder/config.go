@@ -3,27 +3,91 @@ package builder
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 
+	"github.com/squarescale/simple-builder/lib/artifacts"
+	"github.com/squarescale/simple-builder/lib/duration"
 	"github.com/squarescale/simple-builder/lib/gitcloner"
+	"github.com/squarescale/simple-builder/lib/logstream"
+	"github.com/squarescale/simple-builder/lib/notifier"
 	"github.com/squarescale/simple-builder/lib/scriptrunner"
+	"github.com/squarescale/simple-builder/lib/source"
 )
 
+// LatestSchemaVersion is the version of the job format, jobs without a
+// schema_version are version 1.
+//
+// Version 2 honors git_recursive.
+const LatestSchemaVersion = 2
+
 type Config struct {
+	SchemaVersion int `json:"schema_version"`
+
 	Callbacks []string `json:"callbacks"`
 
+	// Send lifecycle events to the callbacks as they happen, not only in
+	// the final payload
+	CallbackEvents bool `json:"callback_events"`
+
+	BuildTimeout duration.Duration `json:"build_timeout"`
+
+	// Delay between SIGTERM and SIGKILL when stopping a command
+	KillGracePeriod duration.Duration `json:"kill_grace_period"`
+
+	// Values masked in the logs and callbacks, on top of git_secret_key
+	// (registry credentials for instance), and regular expressions which
+	// matches are masked as well
+	Secrets        []string `json:"secrets"`
+	RedactPatterns []string `json:"redact_patterns"`
+
+	// Project and environment of the build, SQSC_PROJECT,
+	// SQSC_PROJECT_UUID and SQSC_ENVIRONMENT fill the missing values of
+	// job files, not of the jobs given to NewFromConfig
+	Context *BuildContext `json:"context"`
+
+	// Where the files of the build come from, a git repository unless
+	// source.type says otherwise
+	Source *source.Config `json:"source"`
+
+	// Files of the checkout uploaded once the build script is done
+	Artifacts *artifacts.Config `json:"artifacts"`
+
 	GitCloner    *gitcloner.Config
 	ScriptRunner *scriptrunner.Config
+	Notifier     *notifier.Config
+	LogStream    *logstream.Config
 }
 
+// NewConfigFromFile reads a job file, "-" being stdin, and applies the
+// overrides of the Nomad job meta, see nomad.go.
 func NewConfigFromFile(name string) (*Config, error) {
-	buff, err := ioutil.ReadFile(name)
+	buff, err := ReadJob(name)
+	if err != nil {
+		return nil, &inputError{err}
+	}
+
+	buff, err = applyNomadMeta(buff, os.Environ())
 	if err != nil {
 		return nil, err
 	}
 
+	return NewConfig(buff)
+}
+
+// ReadJob reads a job file, "-" being stdin.
+func ReadJob(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(name)
+}
+
+// NewConfig decodes a job, every component reads its own keys from it.
+func NewConfig(buff []byte) (*Config, error) {
 	c := new(Config)
 
-	err = json.Unmarshal(buff, c)
+	err := json.Unmarshal(buff, c)
 	if err != nil {
 		return nil, err
 	}
@@ -34,14 +98,70 @@ func NewConfigFromFile(name string) (*Config, error) {
 		return nil, err
 	}
 
+	err = clonerCfg.StripURLCredentials()
+	if err != nil {
+		return nil, err
+	}
+
 	runnerCfg := new(scriptrunner.Config)
 	err = json.Unmarshal(buff, runnerCfg)
 	if err != nil {
 		return nil, err
 	}
 
+	notifierCfg := new(notifier.Config)
+	err = json.Unmarshal(buff, notifierCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	streamCfg := new(logstream.Config)
+	err = json.Unmarshal(buff, streamCfg)
+	if err != nil {
+		return nil, err
+	}
+
 	c.GitCloner = clonerCfg
 	c.ScriptRunner = runnerCfg
+	c.Notifier = notifierCfg
+	c.LogStream = streamCfg
+
+	c.setDefaults()
 
 	return c, nil
 }
+
+// setDefaults lets configurations built by hand leave out components.
+func (c *Config) setDefaults() {
+	if c.Context == nil {
+		c.Context = &BuildContext{}
+	}
+
+	if c.Source == nil {
+		c.Source = &source.Config{}
+	}
+
+	if c.Source.Type == "" {
+		c.Source.Type = source.TypeGit
+	}
+
+	if c.Artifacts == nil {
+		c.Artifacts = &artifacts.Config{}
+	}
+
+	if c.GitCloner == nil {
+		c.GitCloner = &gitcloner.Config{}
+	}
+
+	if c.ScriptRunner == nil {
+		c.ScriptRunner = &scriptrunner.Config{}
+	}
+
+	if c.Notifier == nil {
+		c.Notifier = &notifier.Config{}
+	}
+
+	if c.LogStream == nil {
+		c.LogStream = &logstream.Config{}
+	}
+}
@@ -2,9 +2,15 @@ package builder
 
 import (
 	"testing"
+	"time"
 
+	"github.com/squarescale/simple-builder/lib/artifacts"
+	"github.com/squarescale/simple-builder/lib/duration"
 	"github.com/squarescale/simple-builder/lib/gitcloner"
+	"github.com/squarescale/simple-builder/lib/logstream"
+	"github.com/squarescale/simple-builder/lib/notifier"
 	"github.com/squarescale/simple-builder/lib/scriptrunner"
+	"github.com/squarescale/simple-builder/lib/source"
 	"github.com/stretchr/testify/require"
 )
 
@@ -19,6 +25,14 @@ func testNewConfigFromFile(t *testing.T) {
 	require.Nil(t, err)
 
 	require.Equal(t, c, &Config{
+		Context: &BuildContext{},
+
+		Source: &source.Config{
+			Type: source.TypeGit,
+		},
+
+		Artifacts: &artifacts.Config{},
+
 		GitCloner: &gitcloner.Config{
 			RepoURL:     "a",
 			Branch:      "b",
@@ -34,6 +48,18 @@ func testNewConfigFromFile(t *testing.T) {
 			ScriptContents: "foo",
 		},
 
+		Notifier: &notifier.Config{
+			MaxAttempts: 3,
+			InitialBackoff: duration.Duration{
+				Duration: 2 * time.Second,
+			},
+			OutboxDir: "e",
+		},
+
+		LogStream: &logstream.Config{
+			URLs: []string{"ls1"},
+		},
+
 		Callbacks: []string{"cb1", "cb2"},
 	})
 }
@@ -1,6 +1,10 @@
 package builder
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/squarescale/simple-builder/lib/gitcloner"
+)
 
 type BuilderError struct {
 	error
@@ -9,3 +13,30 @@ type BuilderError struct {
 func (err *BuilderError) MarshalJSON() ([]byte, error) {
 	return json.Marshal(err.Error())
 }
+
+// inputError is a job file which cannot be read.
+type inputError struct {
+	error
+}
+
+// internalError is a failure to prepare a build unrelated to the job.
+type internalError struct {
+	error
+}
+
+// ErrorCategory singles out failures that call for a specific handling,
+// such as warning the user rather than retrying.
+type ErrorCategory string
+
+const (
+	ErrorCategoryHostKey ErrorCategory = "host_key_verification"
+)
+
+func errorCategory(err error) ErrorCategory {
+	switch err.(type) {
+	case *gitcloner.HostKeyError:
+		return ErrorCategoryHostKey
+	}
+
+	return ""
+}
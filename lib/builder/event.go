@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventBuildQueued       = "build.queued"
+	EventBuildStarted      = "build.started"
+	EventCloneStarted      = "clone.started"
+	EventCloneFinished     = "clone.finished"
+	EventScriptStarted     = "script.started"
+	EventScriptFinished    = "script.finished"
+	EventArtifactsStarted  = "artifacts.started"
+	EventArtifactsFinished = "artifacts.finished"
+	EventBuildFinished     = "build.finished"
+)
+
+type Event struct {
+	Type string    `json:"event"`
+	Time time.Time `json:"time"`
+
+	// seconds, only for *.finished events
+	Duration float64 `json:"duration,omitempty"`
+	Status   Status  `json:"status,omitempty"`
+
+	// only for script.finished, -1 when killed by a signal
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// emit records a lifecycle event and, when callback_events is enabled,
+// sends it right away to the callbacks. build.finished is never sent on its
+// own, it is part of the final payload.
+func (b *Builder) emit(typ string, since time.Time, status Status) {
+	e := &Event{
+		Type:   typ,
+		Time:   time.Now().UTC(),
+		Status: status,
+	}
+
+	if !since.IsZero() {
+		e.Duration = e.Time.Sub(since).Seconds()
+	}
+
+	if typ == EventScriptFinished && b.Script != nil {
+		code := b.Script.ExitCode
+		e.ExitCode = &code
+	}
+
+	b.Events = append(b.Events, e)
+
+	b.logger.Info().
+		Str("event", e.Type).
+		Str("status", string(e.Status)).
+		Float64("duration", e.Duration).
+		Msg(e.Type)
+
+	if !b.Cfg.CallbackEvents || typ == EventBuildFinished {
+		return
+	}
+
+	// the context lets the receivers route the event
+	data, err := json.Marshal(struct {
+		*Event
+		Context *BuildContext `json:"context,omitempty"`
+	}{e, b.Context})
+
+	if err != nil {
+		return
+	}
+
+	// a single attempt, events must not hold the build back and the final
+	// payload carries all of them anyway
+	for _, cb := range b.Cfg.Callbacks {
+		b.notifier.Post(cb, data)
+	}
+}
@@ -0,0 +1,71 @@
+package builder
+
+// Exit codes of simple-builder. A failed build script always exits with
+// ExitScriptFailed, its own exit code being reported in the payload, values
+// from 64 on are used for failures of simple-builder itself, see the
+// sysexits.h(3) conventions.
+const (
+	ExitSuccess      = 0
+	ExitScriptFailed = 1
+
+	// command line errors
+	ExitUsage = 2
+
+	ExitCloneFailed     = 64
+	ExitNoInput         = 66
+	ExitInternalError   = 70
+	ExitArtifactsFailed = 74
+	ExitCallbackFailed  = 75
+	ExitConfigError     = 78
+
+	// as timeout(1) does
+	ExitTimedOut = 124
+
+	// as a shell interrupted by SIGINT does
+	ExitCancelled = 130
+)
+
+// NewExitCode is the exit status for an error returned by New: the job
+// file cannot be read, the job is invalid, or the build cannot be prepared.
+func NewExitCode(err error) int {
+	switch err.(type) {
+	case *inputError:
+		return ExitNoInput
+
+	case *internalError:
+		return ExitInternalError
+	}
+
+	return ExitConfigError
+}
+
+// ExitCode derives the exit status of the process from the status of the
+// build. Failures to deliver the callbacks only matter when the build
+// succeeded, the build status comes first otherwise.
+func (b *Builder) ExitCode() int {
+	switch b.Status {
+	case StatusSuccess:
+		if b.callbackErr != nil {
+			return ExitCallbackFailed
+		}
+
+		return ExitSuccess
+
+	case StatusScriptFailed:
+		return ExitScriptFailed
+
+	case StatusCloneFailed:
+		return ExitCloneFailed
+
+	case StatusArtifactsFailed:
+		return ExitArtifactsFailed
+
+	case StatusTimedOut:
+		return ExitTimedOut
+
+	case StatusCancelled:
+		return ExitCancelled
+	}
+
+	return ExitInternalError
+}
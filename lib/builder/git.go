@@ -0,0 +1,41 @@
+package builder
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/gitcloner"
+)
+
+// gitEnv exposes the commit checked out to the build script, to tag images
+// for instance.
+func gitEnv(info *gitcloner.CommitInfo) []string {
+	date := ""
+
+	if !info.Date.IsZero() {
+		date = info.Date.Format(time.RFC3339)
+	}
+
+	vars := [][2]string{
+		{"SQSC_GIT_COMMIT", info.SHA},
+		{"SQSC_GIT_SHORT_COMMIT", info.ShortSHA},
+		{"SQSC_GIT_REF", info.Ref},
+		{"SQSC_GIT_BRANCH", info.Branch},
+		{"SQSC_GIT_AUTHOR_NAME", info.AuthorName},
+		{"SQSC_GIT_AUTHOR_EMAIL", info.AuthorEmail},
+		{"SQSC_GIT_COMMITTER_NAME", info.CommitterName},
+		{"SQSC_GIT_COMMITTER_EMAIL", info.CommitterEmail},
+		{"SQSC_GIT_SUBJECT", info.Subject},
+		{"SQSC_GIT_DATE", date},
+	}
+
+	buff := []string{}
+
+	for _, v := range vars {
+		buff = append(
+			buff, fmt.Sprintf("%s=%s", v[0], v[1]),
+		)
+	}
+
+	return buff
+}
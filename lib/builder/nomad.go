@@ -0,0 +1,126 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Nomad exports the meta of a job to its tasks as NOMAD_META_<key>, the
+// key being both as is and upper cased.
+const nomadMetaPrefix = "NOMAD_META_"
+
+// JobFileEnv names the variable giving the job file when -build-job is not,
+// it overrides the dispatch payload of the Nomad task.
+const JobFileEnv = "SIMPLE_BUILDER_JOB"
+
+// nomadPayloadFiles are the names the dispatch_payload stanza of the
+// parameterized job can give to the payload.
+var nomadPayloadFiles = []string{
+	"build-job.json",
+	"payload.json",
+}
+
+// JobFile returns the job file given on the command line, or else in the
+// JobFileEnv variable, or else the dispatch payload of the Nomad task.
+func JobFile(name string, getenv func(string) string) (string, error) {
+	if name == "" {
+		name = getenv(JobFileEnv)
+	}
+
+	if name == "" {
+		name = nomadJobFile(getenv("NOMAD_TASK_DIR"))
+	}
+
+	if name == "" {
+		return "", fmt.Errorf(
+			"no job file, -build-job argument and %s are empty, and there is no Nomad dispatch payload",
+			JobFileEnv,
+		)
+	}
+
+	return name, nil
+}
+
+// nomadJobFile returns the dispatch payload written to the local directory
+// of the Nomad task, if any.
+func nomadJobFile(dir string) string {
+	if dir == "" {
+		return ""
+	}
+
+	for _, name := range nomadPayloadFiles {
+		p := filepath.Join(dir, name)
+
+		fi, err := os.Stat(p)
+		if err == nil && fi.Mode().IsRegular() {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// applyNomadMeta overrides the top level keys of the job with the
+// NOMAD_META_<key> variables of environ. Other meta are ignored.
+func applyNomadMeta(buff []byte, environ []string) ([]byte, error) {
+	fields := jsonFields(reflect.TypeOf(Config{}))
+
+	overrides := map[string]json.RawMessage{}
+
+	for _, e := range environ {
+		if !strings.HasPrefix(e, nomadMetaPrefix) {
+			continue
+		}
+
+		kv := strings.SplitN(
+			strings.TrimPrefix(e, nomadMetaPrefix), "=", 2,
+		)
+
+		k := strings.ToLower(kv[0])
+
+		t, ok := fields[k]
+		if !ok || len(kv) != 2 {
+			continue
+		}
+
+		overrides[k] = metaValue(kv[1], t)
+	}
+
+	if len(overrides) == 0 {
+		return buff, nil
+	}
+
+	job := map[string]json.RawMessage{}
+
+	err := json.Unmarshal(buff, &job)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range overrides {
+		job[k] = v
+	}
+
+	return json.Marshal(job)
+}
+
+// metaValue converts a meta, always a string, to the JSON value of a field
+// of type t: strings are kept as is, other values are JSON, such as true,
+// 3 or ["a", "b"], durations being accepted either way.
+func metaValue(v string, t reflect.Type) json.RawMessage {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.String && json.Valid([]byte(v)) {
+		return json.RawMessage(v)
+	}
+
+	buff, _ := json.Marshal(v)
+
+	return buff
+}
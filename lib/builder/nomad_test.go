@@ -0,0 +1,159 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/scriptrunner"
+	"github.com/squarescale/simple-builder/lib/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyNomadMeta(t *testing.T) {
+	job := []byte(`{"git_url": "a", "git_branch": "b", "build_script": "make"}`)
+
+	buff, err := applyNomadMeta(job, []string{"PATH=/bin"})
+	require.Nil(t, err)
+	require.Equal(t, job, buff)
+
+	// ---
+
+	buff, err = applyNomadMeta(job, []string{
+		"NOMAD_META_git_branch=main",
+		"NOMAD_META_GIT_BRANCH=main",
+		"NOMAD_META_git_commit=1234567",
+		"NOMAD_META_git_full_clone=true",
+		"NOMAD_META_git_sparse_paths=[\"lib\"]",
+		"NOMAD_META_script_timeout=10m",
+		"NOMAD_META_build_timeout=60",
+		"NOMAD_META_owner=team",
+	})
+	require.Nil(t, err)
+
+	cfg, err := NewConfig(buff)
+	require.Nil(t, err)
+
+	require.Equal(t, "a", cfg.GitCloner.RepoURL)
+	require.Equal(t, "main", cfg.GitCloner.Branch)
+	require.Equal(t, "1234567", cfg.GitCloner.Commit)
+	require.True(t, cfg.GitCloner.FullClone)
+	require.Equal(t, []string{"lib"}, cfg.GitCloner.SparsePaths)
+	require.Equal(t, 10*time.Minute, cfg.ScriptRunner.Timeout.Duration)
+	require.Equal(t, time.Minute, cfg.BuildTimeout.Duration)
+
+	// ---
+
+	_, err = applyNomadMeta([]byte(`[]`), []string{"NOMAD_META_git_branch=main"})
+	require.NotNil(t, err)
+}
+
+func TestJobFile(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+
+	_, err := JobFile("", getenv)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), JobFileEnv)
+
+	name, err := JobFile("job.json", getenv)
+	require.Nil(t, err)
+	require.Equal(t, "job.json", name)
+
+	env[JobFileEnv] = "/local/build-job.json"
+
+	name, err = JobFile("", getenv)
+	require.Nil(t, err)
+	require.Equal(t, "/local/build-job.json", name)
+
+	// the command line comes first
+	name, err = JobFile("-", getenv)
+	require.Nil(t, err)
+	require.Equal(t, "-", name)
+
+	// ---
+
+	dir, err := ioutil.TempDir("", "nomadtestsuite")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	delete(env, JobFileEnv)
+	env["NOMAD_TASK_DIR"] = dir
+
+	_, err = JobFile("", getenv)
+	require.NotNil(t, err)
+
+	payload := filepath.Join(dir, "payload.json")
+	require.Nil(t, ioutil.WriteFile(payload, []byte("{}"), 0600))
+
+	name, err = JobFile("", getenv)
+	require.Nil(t, err)
+	require.Equal(t, payload, name)
+
+	// the variable overrides the payload
+	env[JobFileEnv] = "/local/build-job.json"
+
+	name, err = JobFile("", getenv)
+	require.Nil(t, err)
+	require.Equal(t, "/local/build-job.json", name)
+}
+
+func TestReadJobFromStdin(t *testing.T) {
+	f, err := ioutil.TempFile("", "stdin")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`{"build_script": "make"}`)
+	require.Nil(t, err)
+
+	_, err = f.Seek(0, 0)
+	require.Nil(t, err)
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+
+	os.Stdin = f
+
+	cfg, err := NewConfigFromFile("-")
+	require.Nil(t, err)
+	require.Equal(t, "make", cfg.ScriptRunner.ScriptContents)
+}
+
+func TestNewFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nomadtestsuite")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv("SQSC_PROJECT", os.Getenv("SQSC_PROJECT"))
+	os.Setenv("SQSC_PROJECT", "web")
+
+	// no job file, and only the components needed
+	b, err := NewFromConfig(context.Background(), &Config{
+		Source: &source.Config{
+			Type: source.TypeLocal,
+			Path: dir,
+		},
+		ScriptRunner: &scriptrunner.Config{
+			ScriptContents: "#!/bin/sh\necho from config\n",
+		},
+	})
+	require.Nil(t, err)
+	defer b.Cleanup()
+
+	err = b.Run()
+	require.Nil(t, err)
+
+	require.Equal(t, StatusSuccess, b.Status)
+	require.Contains(t, b.Output, "from config")
+
+	// the context of the process is not the one of the job
+	require.Equal(t, "", b.Context.Project)
+
+	// the configuration ends up in the payload
+	_, err = json.Marshal(b)
+	require.Nil(t, err)
+}
@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/procgroup"
+)
+
+// ProcessInfo is the marshallable part of a phase os.ProcessState. Times
+// are in seconds.
+type ProcessInfo struct {
+	ExitCode     int    `json:"exit_code"`
+	Signal       string `json:"signal,omitempty"`
+	SignalNumber int    `json:"signal_number,omitempty"`
+
+	WallTime   float64 `json:"wall_time"`
+	UserTime   float64 `json:"user_time"`
+	SystemTime float64 `json:"system_time"`
+
+	// as reported by getrusage(2), kilobytes on Linux
+	MaxRSS int64 `json:"max_rss"`
+
+	// set when the process group had to be stopped
+	Termination *procgroup.Termination `json:"termination,omitempty"`
+}
+
+func newProcessInfo(s *os.ProcessState, wall time.Duration, t *procgroup.Termination) *ProcessInfo {
+	if s == nil {
+		return nil
+	}
+
+	p := &ProcessInfo{
+		ExitCode: s.ExitCode(),
+
+		WallTime:   wall.Seconds(),
+		UserTime:   s.UserTime().Seconds(),
+		SystemTime: s.SystemTime().Seconds(),
+
+		Termination: t,
+	}
+
+	ws, ok := s.Sys().(syscall.WaitStatus)
+	if ok && ws.Signaled() {
+		p.Signal = ws.Signal().String()
+		p.SignalNumber = int(ws.Signal())
+	}
+
+	ru, ok := s.SysUsage().(*syscall.Rusage)
+	if ok && ru != nil {
+		p.MaxRSS = int64(ru.Maxrss)
+	}
+
+	return p
+}
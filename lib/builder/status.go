@@ -0,0 +1,50 @@
+package builder
+
+import (
+	"context"
+	"os"
+)
+
+type Status string
+
+const (
+	StatusSuccess         Status = "success"
+	StatusCloneFailed     Status = "clone_failed"
+	StatusScriptFailed    Status = "script_failed"
+	StatusArtifactsFailed Status = "artifacts_failed"
+	StatusCancelled       Status = "cancelled"
+	StatusTimedOut        Status = "timed_out"
+	StatusInternalError   Status = "internal_error"
+)
+
+// phaseStatus returns the status of a build which phase returned err.
+// Errors happening before the phase command could even run are ours.
+func phaseStatus(err error, ps *os.ProcessState, failed Status) Status {
+	switch {
+	case err == nil:
+		return StatusSuccess
+
+	case err == context.Canceled:
+		return StatusCancelled
+
+	case err == context.DeadlineExceeded:
+		return StatusTimedOut
+
+	case ps == nil:
+		return StatusInternalError
+	}
+
+	return failed
+}
+
+// taskStatus returns the status of a build which phase, running no command
+// such as the sources other than git, returned err. Errors other than the
+// expiry of the context are the phase's.
+func taskStatus(err error, failed Status) Status {
+	switch err {
+	case nil, context.Canceled, context.DeadlineExceeded:
+		return phaseStatus(err, nil, "")
+	}
+
+	return failed
+}
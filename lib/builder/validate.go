@@ -0,0 +1,481 @@
+package builder
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/squarescale/simple-builder/lib/gitcloner"
+	"github.com/squarescale/simple-builder/lib/source"
+)
+
+// Problem is an issue found in a job, Path is the JSON path of the
+// offending value, such as source.url or git_submodule_credentials[0].url.
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (p *Problem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// Validate reports every problem of a job instead of the first one, and
+// unlike NewConfig rejects unknown keys.
+func Validate(buff []byte) []*Problem {
+	problems := checkObject(
+		"", buff, reflect.TypeOf(Config{}),
+	)
+
+	// unknown keys do not keep the job from being decoded, type errors do
+	// and are reported already
+	cfg, err := NewConfig(buff)
+	if err != nil && len(problems) > 0 {
+		return problems
+	}
+
+	if err != nil {
+		return []*Problem{{Message: err.Error()}}
+	}
+
+	problems = append(problems, checkSemantics(cfg)...)
+	sortProblems(problems)
+
+	return problems
+}
+
+// checkObject compares the keys of the JSON object in buff with the json
+// tags of t, and decodes every value alone so that all type errors are
+// reported.
+func checkObject(path string, buff []byte, t reflect.Type) []*Problem {
+	obj := map[string]json.RawMessage{}
+
+	err := json.Unmarshal(buff, &obj)
+	if err != nil {
+		return []*Problem{{
+			Path:    path,
+			Message: decodeError(err, "an object"),
+		}}
+	}
+
+	fields := jsonFields(t)
+
+	keys := []string{}
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	problems := []*Problem{}
+
+	for _, k := range keys {
+		p := joinPath(path, k)
+
+		ft, ok := fields[k]
+		if !ok {
+			problems = append(problems, &Problem{
+				Path:    p,
+				Message: unknownKey(k, fields),
+			})
+
+			continue
+		}
+
+		problems = append(
+			problems, checkValue(p, obj[k], ft)...,
+		)
+	}
+
+	return problems
+}
+
+func checkValue(path string, buff []byte, t reflect.Type) []*Problem {
+	if string(buff) == "null" {
+		return nil
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case isObject(t):
+		return checkObject(path, buff, t)
+
+	case t.Kind() == reflect.Slice && isObject(t.Elem()):
+		items := []json.RawMessage{}
+
+		err := json.Unmarshal(buff, &items)
+		if err != nil {
+			return []*Problem{{
+				Path:    path,
+				Message: decodeError(err, "an array"),
+			}}
+		}
+
+		problems := []*Problem{}
+
+		for i, item := range items {
+			problems = append(
+				problems,
+				checkValue(fmt.Sprintf("%s[%d]", path, i), item, t.Elem())...,
+			)
+		}
+
+		return problems
+	}
+
+	err := json.Unmarshal(
+		buff, reflect.New(t).Interface(),
+	)
+
+	if err != nil {
+		return []*Problem{{
+			Path:    path,
+			Message: decodeError(err, jsonKind(t)),
+		}}
+	}
+
+	return nil
+}
+
+// jsonFields maps the json tags of the struct t to the type of their field.
+// Untagged structs are components of the job, which keys are at the same
+// level.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	fields := map[string]reflect.Type{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+
+		switch {
+		case tag == "-" || f.PkgPath != "":
+			continue
+
+		case tag == "" && isObject(f.Type):
+			for k, ft := range jsonFields(f.Type) {
+				fields[k] = ft
+			}
+
+		case tag == "":
+			fields[f.Name] = f.Type
+
+		default:
+			fields[tag] = f.Type
+		}
+	}
+
+	return fields
+}
+
+// isObject tells structs decoded field by field apart from those with their
+// own decoding, such as durations.
+func isObject(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+
+	unmarshaler := reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+	return !reflect.PtrTo(t).Implements(unmarshaler)
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+
+	case reflect.Bool:
+		return "a boolean"
+
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+
+	case reflect.Slice:
+		return "an array of " + strings.TrimPrefix(jsonKind(t.Elem()), "a ") + "s"
+
+	case reflect.Map:
+		return "an object"
+	}
+
+	return "valid"
+}
+
+func decodeError(err error, want string) string {
+	switch err.(type) {
+	case *json.UnmarshalTypeError:
+		return fmt.Sprintf("must be %s", want)
+
+	case *json.SyntaxError:
+		return fmt.Sprintf("invalid JSON: %s", err)
+	}
+
+	return err.Error()
+}
+
+func unknownKey(k string, fields map[string]reflect.Type) string {
+	best := ""
+	bestDist := 3
+
+	for name := range fields {
+		d := editDistance(k, name)
+
+		if d < bestDist || d == bestDist && best != "" && name < best {
+			best = name
+			bestDist = d
+		}
+	}
+
+	if best == "" {
+		return "unknown key"
+	}
+
+	return fmt.Sprintf("unknown key, did you mean %q?", best)
+}
+
+func joinPath(path, k string) string {
+	if path == "" {
+		return k
+	}
+
+	return path + "." + k
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(
+				min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost,
+			)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// ---
+
+// scpURLRegexp matches the scp-like syntax of git, user@host:path.
+var scpURLRegexp = regexp.MustCompile(`^([^@/]+@)?[^@/:]+:[^/]`)
+
+type problemList []*Problem
+
+func (l *problemList) add(path, format string, args ...interface{}) {
+	*l = append(*l, &Problem{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// addErr adds the error of a component check, prefixed with its path.
+func (l *problemList) addErr(err error) {
+	if err == nil {
+		return
+	}
+
+	parts := strings.SplitN(err.Error(), ": ", 2)
+
+	if len(parts) == 2 && !strings.Contains(parts[0], " ") {
+		l.add(parts[0], "%s", parts[1])
+	} else {
+		l.add("", "%s", err)
+	}
+}
+
+// checkSemantics reports the values which are well typed but do not make
+// sense.
+func checkSemantics(cfg *Config) []*Problem {
+	problems := problemList{}
+
+	if cfg.SchemaVersion < 0 || cfg.SchemaVersion > LatestSchemaVersion {
+		problems.add(
+			"schema_version", "unsupported version %d, the latest is %d",
+			cfg.SchemaVersion, LatestSchemaVersion,
+		)
+	}
+
+	if cfg.ScriptRunner.ScriptContents == "" {
+		problems.add("build_script", "required")
+	}
+
+	if cfg.ScriptRunner.StrictEnv {
+		err := cfg.ScriptRunner.CheckEnvReferences(declaredEnv(cfg))
+		if err != nil {
+			problems.add(
+				"build_script", "%s",
+				strings.TrimPrefix(err.Error(), "build_script "),
+			)
+		}
+	}
+
+	problems.addErr(cfg.ScriptRunner.CheckSubdir())
+
+	for i, u := range cfg.Callbacks {
+		if !isHTTPURL(u) {
+			problems.add(fmt.Sprintf("callbacks[%d]", i), "%q is not an HTTP URL", u)
+		}
+	}
+
+	for i, u := range cfg.LogStream.URLs {
+		if !isHTTPURL(u) {
+			problems.add(fmt.Sprintf("log_stream_callbacks[%d]", i), "%q is not an HTTP URL", u)
+		}
+	}
+
+	_, err := initMasker(cfg)
+	if err != nil {
+		problems.add("redact_patterns", "%s", err)
+	}
+
+	problems.addErr(cfg.Source.Check())
+	problems.addErr(cfg.Artifacts.Check())
+
+	if cfg.Source.Type == source.TypeArchive && cfg.Source.URL != "" && !isHTTPURL(cfg.Source.URL) {
+		problems.add("source.url", "%q is not an HTTP URL", cfg.Source.URL)
+	}
+
+	if cfg.Source.Type == source.TypeGit {
+		checkGit(cfg.GitCloner, &problems)
+	}
+
+	sortProblems(problems)
+
+	return problems
+}
+
+func sortProblems(problems []*Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Path < problems[j].Path
+	})
+}
+
+func checkGit(c *gitcloner.Config, problems *problemList) {
+	switch {
+	case c.RepoURL == "":
+		problems.add("git_url", "required")
+
+	case !isGitURL(c.RepoURL):
+		problems.add("git_url", "%q is not a git URL", c.RepoURL)
+	}
+
+	problems.addErr(c.CheckCommit())
+
+	if c.SSHKeyContents != "" && !isPrivateKey(c.SSHKeyContents) {
+		problems.add("git_secret_key", "not a PEM encoded private key")
+	}
+
+	if c.SSHKeyPassphrase != "" && c.SSHKeyContents == "" {
+		problems.add("git_secret_key_passphrase", "set without git_secret_key")
+	}
+
+	if c.SSHAgentForward && c.SSHKeyContents == "" {
+		problems.add("git_ssh_agent_forward", "set without git_secret_key")
+	}
+
+	if c.Token != "" && c.RepoURL != "" && !isHTTPURL(c.RepoURL) {
+		problems.add("git_token", "only used with an HTTP git_url")
+	}
+
+	for i, sc := range c.SubmoduleCredentials {
+		if !isHTTPURL(sc.URL) {
+			problems.add(
+				fmt.Sprintf("git_submodule_credentials[%d].url", i),
+				"%q is not an HTTP URL", sc.URL,
+			)
+		}
+	}
+
+	if c.SubmoduleDepth < 0 {
+		problems.add("git_submodule_depth", "must not be negative")
+	}
+
+	if c.CacheMaxBytes < 0 {
+		problems.add("git_cache_max_bytes", "must not be negative")
+	}
+
+	if c.CacheDir != "" && !filepath.IsAbs(c.CacheDir) {
+		problems.add("git_cache_dir", "%q is not an absolute path", c.CacheDir)
+	}
+}
+
+func isHTTPURL(u string) bool {
+	parsed, err := url.Parse(u)
+
+	return err == nil &&
+		(parsed.Scheme == "http" || parsed.Scheme == "https") &&
+		parsed.Host != ""
+}
+
+// isGitURL accepts the URLs git clone does: URLs with a scheme, scp-like
+// addresses and local paths.
+func isGitURL(u string) bool {
+	if filepath.IsAbs(u) || scpURLRegexp.MatchString(u) {
+		return true
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "ssh", "git", "http", "https":
+		return parsed.Host != ""
+
+	case "file":
+		return parsed.Path != ""
+	}
+
+	return false
+}
+
+func isPrivateKey(k string) bool {
+	block, _ := pem.Decode([]byte(k))
+
+	return block != nil && strings.HasSuffix(block.Type, "PRIVATE KEY")
+}
@@ -0,0 +1,114 @@
+package builder
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	buff, err := ioutil.ReadFile("testdata/config.json")
+	require.Nil(t, err)
+
+	// local paths in git_url and callbacks are fine to decode, not to run
+	require.Equal(t,
+		[]string{
+			`callbacks[0]: "cb1" is not an HTTP URL`,
+			`callbacks[1]: "cb2" is not an HTTP URL`,
+			`git_secret_key: not a PEM encoded private key`,
+			`git_url: "a" is not a git URL`,
+			`log_stream_callbacks[0]: "ls1" is not an HTTP URL`,
+		},
+		problemStrings(Validate(buff)),
+	)
+
+	testCases := []struct {
+		job      string
+		problems []string
+	}{
+		{
+			`{"git_url": "git@github.com:org/repo.git", "build_script": "make"}`,
+			[]string{},
+		},
+		{
+			`{"git_url": "git@github.com:org/repo.git", "git_brnach": "main", "build_script": "make"}`,
+			[]string{`git_brnach: unknown key, did you mean "git_branch"?`},
+		},
+		{
+			`{"git_url": 42, "git_full_clone": "yes", "script_timeout": "-1s", "build_script": "make"}`,
+			[]string{
+				`git_full_clone: must be a boolean`,
+				`git_url: must be a string`,
+				`script_timeout: negative duration "-1s"`,
+			},
+		},
+		{
+			`{"source": {"type": "archive", "ur": "x"}, "build_script": "make"}`,
+			[]string{
+				`source.ur: unknown key, did you mean "url"?`,
+				`source.url: required by archive sources`,
+			},
+		},
+		{
+			`{"git_url": "/srv/repo", "git_brnach": "main", "callbacks": ["ftp://x"]}`,
+			[]string{
+				`build_script: required`,
+				`callbacks[0]: "ftp://x" is not an HTTP URL`,
+				`git_brnach: unknown key, did you mean "git_branch"?`,
+			},
+		},
+		{
+			`{"git_url": "https://github.com/org/repo", "build_script": "make",
+			  "git_submodule_credentials": [{"url": "https://github.com/org"}, {"url": 1}]}`,
+			[]string{`git_submodule_credentials[1].url: must be a string`},
+		},
+		{
+			`{"source": {"type": "archive", "url": "ftp://x/a.tgz", "sha256": "00"},
+			  "callbacks": ["https://example.com/cb"], "schema_version": 9}`,
+			[]string{
+				`build_script: required`,
+				`schema_version: unsupported version 9, the latest is 2`,
+				`source.sha256: "00" is not a SHA-256 checksum`,
+				`source.url: "ftp://x/a.tgz" is not an HTTP URL`,
+			},
+		},
+		{
+			`{"git_url": "/srv/repo", "git_commit": "main", "strict_env": true,
+			  "build_script": "echo $HOME $SQSC_GIT_COMMIT $TAG"}`,
+			[]string{
+				`build_script: references undeclared environment variables: TAG`,
+				`git_commit: "main" is not a commit SHA`,
+			},
+		},
+		{
+			`{"git_url": "/srv/repo", "build_script": "make",
+			  "artifacts": {"paths": ["../dist/*"], "bucket": "builds"}}`,
+			[]string{`artifacts.paths: "../dist/*" is not within the checkout`},
+		},
+		{
+			`[]`,
+			[]string{`must be an object`},
+		},
+		{
+			`{"git_url": `,
+			[]string{`invalid JSON: unexpected end of JSON input`},
+		},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t,
+			tc.problems, problemStrings(Validate([]byte(tc.job))), tc.job,
+		)
+	}
+}
+
+func problemStrings(problems []*Problem) []string {
+	buff := []string{}
+
+	for _, p := range problems {
+		buff = append(buff, p.String())
+	}
+
+	return buff
+}
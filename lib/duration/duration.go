@@ -0,0 +1,58 @@
+package duration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that can be read from job files either as a
+// Go duration string ("90s", "1m30s") or as a number of seconds.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(buff []byte) error {
+	var v interface{}
+
+	err := json.Unmarshal(buff, &v)
+	if err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		d.Duration = time.Duration(value * float64(time.Second))
+
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+
+		d.Duration = parsed
+
+	default:
+		return fmt.Errorf("invalid duration %s", string(buff))
+	}
+
+	if d.Duration < 0 {
+		return fmt.Errorf("negative duration %s", string(buff))
+	}
+
+	return nil
+}
+
+// Context bounds ctx to d, a zero duration meaning no timeout.
+func Context(ctx context.Context, d Duration) (context.Context, context.CancelFunc) {
+	if d.Duration == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, d.Duration)
+}
@@ -0,0 +1,83 @@
+package duration
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		input    string
+		expected time.Duration
+		err      bool
+	}{
+		{
+			desc:     "duration string",
+			input:    `"1m30s"`,
+			expected: 90 * time.Second,
+		},
+		{
+			desc:     "number of seconds",
+			input:    `2.5`,
+			expected: 2500 * time.Millisecond,
+		},
+		{
+			desc:  "invalid string",
+			input: `"plop"`,
+			err:   true,
+		},
+		{
+			desc:  "negative duration",
+			input: `"-1s"`,
+			err:   true,
+		},
+		{
+			desc:  "invalid type",
+			input: `true`,
+			err:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		d := Duration{}
+
+		err := json.Unmarshal([]byte(tc.input), &d)
+
+		if tc.err {
+			require.NotNil(t, err, tc.desc)
+			continue
+		}
+
+		require.Nil(t, err, tc.desc)
+		require.Equal(t, tc.expected, d.Duration, tc.desc)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	buff, err := json.Marshal(
+		Duration{90 * time.Second},
+	)
+
+	require.Nil(t, err)
+	require.Equal(t, `"1m30s"`, string(buff))
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancelFunc := Context(context.Background(), Duration{})
+	defer cancelFunc()
+
+	_, ok := ctx.Deadline()
+	require.False(t, ok)
+
+	ctx, cancelFunc = Context(context.Background(), Duration{time.Minute})
+	defer cancelFunc()
+
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+}
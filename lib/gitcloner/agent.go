@@ -0,0 +1,170 @@
+package gitcloner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	agentSocketFile  = "agent.sock"
+	agentAskPassFile = "askpass"
+)
+
+// how long to wait for ssh-agent to listen on its socket
+const agentStartTimeout = 5 * time.Second
+
+func (c *Config) useAgent() bool {
+	return len(c.SSHKeyContents) > 0 &&
+		(c.SSHAgent || c.SSHKeyPassphrase != "")
+}
+
+// AuthSock returns the socket of the ssh-agent holding the secret key, if
+// one is running.
+func (c *Cloner) AuthSock() string {
+	if c.agent == nil {
+		return ""
+	}
+
+	return filepath.Join(
+		c.Cfg.SSHKeyDir, agentSocketFile,
+	)
+}
+
+// Cleanup stops the ssh-agent, if any.
+func (c *Cloner) Cleanup() {
+	if c.agent == nil {
+		return
+	}
+
+	c.agent.Process.Kill()
+	c.agent.Wait()
+
+	os.Remove(c.AuthSock())
+
+	c.agent = nil
+}
+
+// startAgent starts a private ssh-agent, loads the secret key in it and
+// removes the key file, which is not needed anymore.
+func (c *Cloner) startAgent(ctx context.Context) error {
+	sock := filepath.Join(
+		c.Cfg.SSHKeyDir, agentSocketFile,
+	)
+
+	cmd := exec.Command(
+		"ssh-agent", "-D", "-a", sock,
+	)
+
+	// not bound to the clone, the build script may use the agent as well
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("ssh-agent: %s", err)
+	}
+
+	c.agent = cmd
+
+	err = waitForSocket(ctx, sock)
+	if err != nil {
+		c.Cleanup()
+		return err
+	}
+
+	err = c.addKey(ctx)
+	if err != nil {
+		c.Cleanup()
+		return err
+	}
+
+	return os.Remove(c.keyPath())
+}
+
+func (c *Cloner) addKey(ctx context.Context) error {
+	askPass := filepath.Join(
+		c.Cfg.SSHKeyDir, agentAskPassFile,
+	)
+
+	// ssh-add asks again and again while the passphrase is wrong, answer
+	// only once
+	used := askPass + ".used"
+
+	script := strings.Join([]string{
+		"#!/bin/sh",
+		fmt.Sprintf("[ -e %s ] && exit 1", shellQuote(used)),
+		fmt.Sprintf("touch %s", shellQuote(used)),
+		fmt.Sprintf("echo %s", shellQuote(c.Cfg.SSHKeyPassphrase)),
+	}, "\n")
+
+	err := ioutil.WriteFile(
+		askPass, []byte(script+"\n"), 0700,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(askPass)
+	defer os.Remove(used)
+
+	cmd := exec.CommandContext(
+		ctx, "ssh-add", c.keyPath(),
+	)
+
+	// without a terminal ssh-add asks SSH_ASKPASS for the passphrase
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	// a new slice, ExtraEnv is shared with the git commands, the last
+	// value of a variable wins
+	cmd.Env = append(
+		os.Environ(), c.Cfg.ExtraEnv...,
+	)
+
+	cmd.Env = append(
+		cmd.Env,
+		"SSH_AUTH_SOCK="+c.AuthSock(),
+		"SSH_ASKPASS="+askPass,
+		"SSH_ASKPASS_REQUIRE=force",
+		"DISPLAY=none",
+	)
+
+	out, err := cmd.CombinedOutput()
+
+	c.Cfg.Logger.Info().Msgf("ssh-add: %s", out)
+
+	if err != nil {
+		return fmt.Errorf("ssh-add: %s", err)
+	}
+
+	return nil
+}
+
+func (c *Cloner) keyPath() string {
+	return filepath.Join(
+		c.Cfg.SSHKeyDir, c.Cfg.SSHKeyFile,
+	)
+}
+
+func waitForSocket(ctx context.Context, path string) error {
+	ctx, cancelFunc := context.WithTimeout(ctx, agentStartTimeout)
+	defer cancelFunc()
+
+	for {
+		_, err := os.Stat(path)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("ssh-agent: %s not created", path)
+
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package gitcloner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const lockPollInterval = 100 * time.Millisecond
+
+const (
+	// held exclusively while a mirror is created or updated
+	updateLockExt = ".lock"
+
+	// held shared while a mirror is cloned from, exclusively to evict it
+	useLockExt = ".use"
+)
+
+// useMirror brings the mirror of the repository kept in the cache up to
+// date, so that the clone only fetches what the mirror lacks. Other builders
+// may update the mirror once this is done, it is only kept from eviction
+// until the returned function is called.
+func (c *Cloner) useMirror(ctx context.Context) (func(), error) {
+	cfg := c.Cfg
+
+	err := os.MkdirAll(cfg.CacheDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	key := mirrorKey(cfg.RepoURL)
+	dir := filepath.Join(cfg.CacheDir, key+".git")
+
+	lockPath := filepath.Join(cfg.CacheDir, key+updateLockExt)
+
+	unlock, err := lockFile(ctx, lockPath, syscall.LOCK_EX)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	_, err = os.Stat(dir)
+
+	switch {
+	case os.IsNotExist(err):
+		err = c.run(
+			ctx, cfg.CacheDir, nil, "clone", "--mirror", cfg.RepoURL, dir,
+		)
+
+		if err != nil {
+			os.RemoveAll(dir)
+			os.Remove(lockPath)
+			return nil, err
+		}
+
+	case err != nil:
+		return nil, err
+
+	default:
+		// the same repository may be reached through another URL
+		err = c.run(ctx, dir, nil, "remote", "set-url", "origin", cfg.RepoURL)
+		if err == nil {
+			err = c.run(ctx, dir, nil, "fetch", "--prune", "origin")
+		}
+
+		// still useful, the clone fetches whatever is missing
+		if err != nil {
+			cfg.Logger.Warn().Msgf("Git cache: could not update %s: %s", dir, err)
+		}
+	}
+
+	// taken before the update lock is released, nothing can evict the
+	// mirror in between
+	release, err := lockFile(
+		ctx, filepath.Join(cfg.CacheDir, key+useLockExt), syscall.LOCK_SH,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+
+	c.mirror = dir
+
+	return release, nil
+}
+
+// evictMirrors removes the least recently used mirrors until the cache fits
+// in CacheMaxBytes. The mirrors in use are kept.
+func (c *Cloner) evictMirrors() error {
+	if c.Cfg.CacheMaxBytes <= 0 {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(c.Cfg.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	type mirror struct {
+		dir  string
+		size int64
+	}
+
+	mirrors := []mirror{}
+	total := int64(0)
+
+	// least recently used first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasSuffix(e.Name(), ".git") {
+			continue
+		}
+
+		dir := filepath.Join(c.Cfg.CacheDir, e.Name())
+
+		size, err := dirSize(dir)
+		if err != nil {
+			return err
+		}
+
+		mirrors = append(mirrors, mirror{dir, size})
+		total += size
+	}
+
+	for _, m := range mirrors {
+		if total <= c.Cfg.CacheMaxBytes {
+			break
+		}
+
+		if m.dir == c.mirror {
+			continue
+		}
+
+		unlock, err := lockMirror(m.dir)
+		if err != nil {
+			continue
+		}
+
+		c.Cfg.Logger.Info().Msgf("Git cache: evicting %s", m.dir)
+
+		// the lock files go along, while still held
+		err = os.RemoveAll(m.dir)
+		if err == nil {
+			err = removeLocks(m.dir)
+		}
+
+		unlock()
+
+		if err != nil {
+			return err
+		}
+
+		total -= m.size
+	}
+
+	return nil
+}
+
+func (c *Cloner) alternatesPath() string {
+	return filepath.Join(
+		c.Cfg.CheckoutDir, ".git", "objects", "info", "alternates",
+	)
+}
+
+// borrowMirror lets the checkout use the objects of the mirror, as
+// git clone --reference does.
+func (c *Cloner) borrowMirror() error {
+	return ioutil.WriteFile(
+		c.alternatesPath(),
+		[]byte(filepath.Join(c.mirror, "objects")+"\n"),
+		0644,
+	)
+}
+
+// dissociate copies the objects borrowed from the mirror, as
+// git clone --dissociate does.
+func (c *Cloner) dissociate(ctx context.Context) error {
+	err := c.git(ctx, "repack", "-a", "-d", "-q")
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(c.alternatesPath())
+}
+
+func mirrorKey(repoURL string) string {
+	sum := sha256.Sum256(
+		[]byte(normalizeURL(repoURL)),
+	)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeURL makes the different spellings of a repository URL match:
+// scheme, user, case of the host and .git suffix are ignored.
+func normalizeURL(s string) string {
+	host, path := "", s
+
+	u, err := url.Parse(s)
+
+	if err == nil && strings.Contains(s, "://") {
+		host, path = u.Host, u.Path
+	} else if i := strings.Index(s, ":"); i > 0 && !strings.Contains(s[:i], "/") {
+		// scp-like syntax, user@host:path
+		host, path = s[:i], s[i+1:]
+
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+	}
+
+	path = strings.TrimSuffix(
+		strings.Trim(path, "/"), ".git",
+	)
+
+	if host == "" {
+		return path
+	}
+
+	return strings.ToLower(host) + "/" + path
+}
+
+func dirSize(dir string) (int64, error) {
+	size := int64(0)
+
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
+
+// lockMirror locks a mirror neither being updated nor cloned from, without
+// waiting.
+func lockMirror(dir string) (func(), error) {
+	base := strings.TrimSuffix(dir, ".git")
+
+	unlock, err := tryLockFile(base+updateLockExt, syscall.LOCK_EX)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := tryLockFile(base+useLockExt, syscall.LOCK_EX)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return func() {
+		release()
+		unlock()
+	}, nil
+}
+
+func removeLocks(dir string) error {
+	base := strings.TrimSuffix(dir, ".git")
+
+	for _, ext := range []string{useLockExt, updateLockExt} {
+		err := os.Remove(base + ext)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockFile waits for a lock on path, shared by the builders of the host,
+// how being syscall.LOCK_EX or syscall.LOCK_SH.
+func lockFile(ctx context.Context, path string, how int) (func(), error) {
+	for {
+		unlock, err := tryLockFile(path, how)
+		if err != syscall.EWOULDBLOCK {
+			return unlock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func tryLockFile(path string, how int) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(
+		int(f.Fd()), how|syscall.LOCK_NB,
+	)
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// removed by its holder in the meantime, the lock is on another file now
+	if !samePath(f, path) {
+		f.Close()
+		return nil, syscall.EWOULDBLOCK
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func samePath(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fi, pi)
+}
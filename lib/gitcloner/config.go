@@ -1,28 +1,74 @@
 package gitcloner
 
 import (
+	"fmt"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/redact"
 )
 
 type Config struct {
 	RepoURL     string `json:"git_url"`
 	Branch      string `json:"git_branch"`
+	Ref         string `json:"git_ref"`
+	Commit      string `json:"git_commit"`
 	CheckoutDir string `json:"git_checkout_dir"`
 
 	SSHKeyContents string `json:"git_secret_key"`
 	SSHKeyFile     string `json:"-"`
 	SSHKeyDir      string `json:"-"`
+	KnownHosts     string `json:"git_known_hosts"`
+
+	// The keys of the hosts neither pinned nor in git_known_hosts are
+	// accepted the first time they are seen, instead of failing the clone
+	AcceptNewHostKeys bool `json:"git_accept_new_host_keys"`
+
+	// The secret key is loaded in a private ssh-agent rather than read from
+	// disk, which is implied by a passphrase
+	SSHKeyPassphrase string `json:"git_secret_key_passphrase"`
+	SSHAgent         bool   `json:"git_ssh_agent"`
+	SSHAgentForward  bool   `json:"git_ssh_agent_forward"`
+
+	Username string `json:"git_username"`
+	Token    string `json:"git_token"`
 
 	FullClone bool `json:"git_full_clone"`
 	Recursive bool `json:"git_recursive"`
 
+	// Only check out these directories (cone mode), and leave out of the
+	// clone the objects matching the filter, blob:none for instance
+	SparsePaths []string `json:"git_sparse_paths"`
+	Filter      string   `json:"git_filter"`
+
+	// Fetch the Git LFS objects, all of them unless include or exclude
+	// patterns are given
+	LFS        bool     `json:"git_lfs"`
+	LFSInclude []string `json:"git_lfs_include"`
+	LFSExclude []string `json:"git_lfs_exclude"`
+
+	// Only used along with git_recursive
+	SubmodulePaths       []string                `json:"git_submodule_paths"`
+	SubmoduleDepth       int                     `json:"git_submodule_depth"`
+	SubmoduleCredentials []*SubmoduleCredentials `json:"git_submodule_credentials"`
+
+	// Bare mirrors of the repositories are kept there, typically a host
+	// volume shared by the builders
+	CacheDir      string `json:"git_cache_dir"`
+	CacheMaxBytes int64  `json:"git_cache_max_bytes"`
+
+	Timeout         duration.Duration `json:"clone_timeout"`
+	KillGracePeriod time.Duration     `json:"-"`
+
 	WorkDir  string   `json:"-"`
 	ExtraEnv []string `json:"-"`
 
 	Logger zerolog.Logger `json:"-"`
+	Masker *redact.Masker `json:"-"`
 }
 
 func (c *Config) setCheckoutDir() {
@@ -40,3 +86,32 @@ func (c *Config) setCheckoutDir() {
 		c.WorkDir, b,
 	)
 }
+
+var commitRegexp = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// CheckCommit makes sure git_commit looks like a commit SHA.
+func (c *Config) CheckCommit() error {
+	if c.Commit == "" || commitRegexp.MatchString(c.Commit) {
+		return nil
+	}
+
+	return fmt.Errorf("git_commit: %q is not a commit SHA", c.Commit)
+}
+
+// ref is the ref to fetch, git_branch is used when git_ref is not set.
+func (c *Config) ref() string {
+	if c.Ref != "" {
+		return c.Ref
+	}
+
+	return c.Branch
+}
+
+// target is the object fetched alone in a shallow fetch.
+func (c *Config) target() string {
+	if c.Commit != "" {
+		return c.Commit
+	}
+
+	return c.ref()
+}
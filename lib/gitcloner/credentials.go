@@ -0,0 +1,95 @@
+package gitcloner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// defaultUsername is accepted along with a token by GitHub, for both
+// personal access tokens and app installation tokens.
+const defaultUsername = "x-access-token"
+
+const askPassFile = "git-askpass"
+
+// StripURLCredentials moves the credentials found in an HTTP(S) git_url to
+// git_username and git_token, so that the token is neither logged nor
+// persisted in the remote URL.
+func (c *Config) StripURLCredentials() error {
+	if !strings.HasPrefix(c.RepoURL, "http://") &&
+		!strings.HasPrefix(c.RepoURL, "https://") {
+		return nil
+	}
+
+	u, err := url.Parse(c.RepoURL)
+	if err != nil {
+		return fmt.Errorf("git_url: %s", err)
+	}
+
+	if u.User == nil {
+		return nil
+	}
+
+	password, found := u.User.Password()
+	if !found {
+		return nil
+	}
+
+	if c.Username == "" {
+		c.Username = u.User.Username()
+	}
+
+	if c.Token == "" {
+		c.Token = password
+	}
+
+	u.User = nil
+	c.RepoURL = u.String()
+
+	return nil
+}
+
+func (c *Cloner) askPassPath() string {
+	return filepath.Join(
+		c.Cfg.WorkDir, askPassFile,
+	)
+}
+
+// writeAskPass writes the script git runs to get the credentials. It only
+// lives as long as the clone.
+func (c *Cloner) writeAskPass() error {
+	username := c.Cfg.Username
+
+	if username == "" {
+		username = defaultUsername
+	}
+
+	script := strings.Join([]string{
+		"#!/bin/sh",
+		`case "$1" in`,
+		fmt.Sprintf("Username*) echo %s ;;", shellQuote(username)),
+		fmt.Sprintf("*) echo %s ;;", shellQuote(c.Cfg.Token)),
+		"esac",
+	}, "\n")
+
+	return ioutil.WriteFile(
+		c.askPassPath(), []byte(script+"\n"), 0700,
+	)
+}
+
+func (c *Cloner) askPassEnv() []string {
+	if c.Cfg.Token == "" {
+		return nil
+	}
+
+	return []string{
+		"GIT_ASKPASS=" + c.askPassPath(),
+		"GIT_TERMINAL_PROMPT=0",
+	}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -4,16 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/procgroup"
+	"github.com/squarescale/simple-builder/lib/redact"
 )
 
+// fetchedRef holds the ref fetched when checking out git_ref or git_commit
+const fetchedRef = "refs/simple-builder/fetched"
+
 type Cloner struct {
 	Cfg          *Config
+	Info         *CommitInfo
 	ProcessState *os.ProcessState
+	Termination  *procgroup.Termination
+
+	hostKey *hostKeyWatcher
+	agent   *exec.Cmd
+	mirror  string
+	lfs     *LFSStats
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
@@ -27,13 +42,28 @@ func New(ctx context.Context, cfg *Config) *Cloner {
 	return &Cloner{
 		Cfg: cfg,
 
+		hostKey: &hostKeyWatcher{},
+
 		ctx:        ctx2,
 		cancelFunc: cancelFunc,
 	}
 }
 
 func (c *Cloner) Run() error {
-	err := c.ctx.Err()
+	ctx, cancelFunc := duration.Context(c.ctx, c.Cfg.Timeout)
+	defer cancelFunc()
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	err = c.Cfg.CheckCommit()
+	if err != nil {
+		return err
+	}
+
+	err = c.Cfg.StripURLCredentials()
 	if err != nil {
 		return err
 	}
@@ -43,50 +73,308 @@ func (c *Cloner) Run() error {
 		return err
 	}
 
+	err = c.writeKnownHosts()
+	if err != nil {
+		return err
+	}
+
+	if c.Cfg.useAgent() && c.agent == nil {
+		err = c.startAgent(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Cfg.Token != "" {
+		err = c.writeAskPass()
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(c.askPassPath())
+	}
+
+	if len(c.Cfg.SubmoduleCredentials) > 0 {
+		err = c.writeCredentialHelper()
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(c.credentialHelperPath())
+	}
+
+	if c.Cfg.LFS {
+		err = c.checkLFS(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Cfg.CacheDir != "" {
+		release, err := c.useMirror(ctx)
+
+		if err != nil {
+			c.Cfg.Logger.Warn().Msgf("Git cache not used: %s", err)
+		} else {
+			defer release()
+		}
+	}
+
+	if c.Cfg.Ref == "" && c.Cfg.Commit == "" {
+		err = c.clone(ctx)
+	} else {
+		err = c.fetch(ctx)
+	}
+
+	if err == nil && c.Cfg.updateSubmodules() {
+		err = c.git(ctx, c.submoduleArgs()...)
+	}
+
+	if err == nil && c.Cfg.LFS {
+		err = c.lfsPull(ctx)
+	}
+
+	if err != nil && c.hostKey.failed {
+		return &HostKeyError{err}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if c.Cfg.CacheDir != "" {
+		err = c.evictMirrors()
+		if err != nil {
+			c.Cfg.Logger.Warn().Msgf("Git cache eviction: %s", err)
+		}
+	}
+
+	return c.resolveCommit(ctx)
+}
+
+// Dir returns the directory the repository is checked out in.
+func (c *Cloner) Dir() string {
+	return c.Cfg.CheckoutDir
+}
+
+func (c *Cloner) clone(ctx context.Context) error {
+	err := c.run(
+		ctx, c.Cfg.WorkDir, nil, c.cmdArgs()...,
+	)
+
+	if err != nil || len(c.Cfg.SparsePaths) == 0 {
+		return err
+	}
+
+	return c.sparseCheckout(ctx)
+}
+
+// fetch checks out the exact object asked for in a detached HEAD. The object
+// is fetched alone when the server allows it, the fetch falls back to the
+// whole ref, or to every branch and tag, otherwise.
+func (c *Cloner) fetch(ctx context.Context) error {
+	cfg := c.Cfg
+
+	err := c.run(
+		ctx, cfg.WorkDir, nil, "init", cfg.CheckoutDir,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	err = c.git(ctx, "remote", "add", "origin", cfg.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	if c.mirror != "" {
+		err = c.borrowMirror()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.SparsePaths) > 0 {
+		err = c.sparseCheckout(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !cfg.FullClone {
+		err = c.git(ctx, c.shallowFetchArgs()...)
+		if err != nil && ctx.Err() == nil {
+			cfg.Logger.Warn().Msgf(
+				"Shallow fetch of %s failed, falling back to a deeper fetch",
+				cfg.target(),
+			)
+		}
+	}
+
+	if cfg.FullClone || err != nil {
+		err = c.git(ctx, c.fetchArgs()...)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = c.git(ctx, c.checkoutArgs()...)
+	if err != nil {
+		return err
+	}
+
+	if c.mirror != "" {
+		return c.dissociate(ctx)
+	}
+
+	return nil
+}
+
+// sparseCheckout restricts the working tree to SparsePaths.
+func (c *Cloner) sparseCheckout(ctx context.Context) error {
+	err := c.git(ctx, "sparse-checkout", "init", "--cone")
+	if err != nil {
+		return err
+	}
+
+	return c.git(ctx, c.sparseArgs()...)
+}
+
+// resolveCommit describes the checked out HEAD, and makes sure it is the
+// requested commit, if any.
+func (c *Cloner) resolveCommit(ctx context.Context) error {
+	info, err := c.inspect(ctx)
+	if err != nil {
+		return err
+	}
+
+	info.LFS = c.lfs
+
+	c.Info = info
+
+	c.Cfg.Logger.Info().Msgf("COMMIT: %s %s", info.SHA, info.Subject)
+
+	want := strings.ToLower(c.Cfg.Commit)
+
+	if !strings.HasPrefix(info.SHA, want) {
+		return fmt.Errorf(
+			"checked out commit %s instead of %s", info.SHA, c.Cfg.Commit,
+		)
+	}
+
+	return nil
+}
+
+func (c *Cloner) git(ctx context.Context, args ...string) error {
+	return c.run(
+		ctx, c.Cfg.CheckoutDir, nil, args...,
+	)
+}
+
+// run executes a single git command in dir, its output goes to the logger
+// unless stdout is provided. Its process state is the one reported for the
+// clone.
+func (c *Cloner) run(ctx context.Context, dir string, stdout io.Writer, args ...string) error {
+	s, err := c.command(ctx, dir, stdout, args...)
+
+	if s != nil {
+		c.ProcessState = s
+	}
+
+	return err
+}
+
+// command executes a git command as run does, without recording its process
+// state, for the commands inspecting the checkout.
+func (c *Cloner) command(ctx context.Context, dir string, stdout io.Writer, args ...string) (*os.ProcessState, error) {
 	cmd := exec.Command(
-		"git", c.cmdArgs()...,
+		"git", append(c.configArgs(), args...)...,
+	)
+
+	cmd.Dir = dir
+
+	procgroup.Setup(cmd)
+
+	stderr := redact.NewWriter(
+		io.MultiWriter(c.Cfg.Logger, c.hostKey), c.Cfg.Masker,
 	)
+	logged := redact.NewWriter(c.Cfg.Logger, c.Cfg.Masker)
 
-	cmd.Dir = c.Cfg.WorkDir
+	if stdout == nil {
+		stdout = logged
+	}
 
-	cmd.Stdout = c.Cfg.Logger
-	cmd.Stderr = c.Cfg.Logger
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	cmd.Env = append(
 		cmd.Env, c.gitSSHCommand(),
 	)
 
+	cmd.Env = append(
+		cmd.Env, c.askPassEnv()...,
+	)
+
+	if c.Cfg.LFS {
+		// the objects are fetched by git lfs pull, once cloned
+		cmd.Env = append(
+			cmd.Env, "GIT_LFS_SKIP_SMUDGE=1",
+		)
+	}
+
 	cmd.Env = append(
 		cmd.Env, c.Cfg.ExtraEnv...,
 	)
 
+	if c.agent != nil {
+		cmd.Env = append(
+			cmd.Env, "SSH_AUTH_SOCK="+c.AuthSock(),
+		)
+	}
+
 	c.dumpCmd(cmd)
 
-	err = c.ctx.Err()
+	err := ctx.Err()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = cmd.Start()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	// the state is sent first, it is there once errChan got the result
+	stateChan := make(chan *os.ProcessState, 1)
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- cmd.Wait()
-		c.ProcessState = cmd.ProcessState
+		err := cmd.Wait()
+		logged.Flush()
+		stderr.Flush()
+		stateChan <- cmd.ProcessState
+		errChan <- err
 	}()
 
 	select {
-	case <-c.ctx.Done():
-		cmd.Process.Kill()
+	case <-ctx.Done():
+		c.Cfg.Logger.Error().Msgf(
+			"\nContext expired (%s), terminating command\n\n", ctx.Err(),
+		)
 
-		c.Cfg.Logger.Error().Msg(
-			"\nContext expired, command killed\n\n",
+		c.Termination = procgroup.Terminate(
+			cmd, errChan, c.Cfg.KillGracePeriod, c.Cfg.Logger,
 		)
 
-		return c.ctx.Err()
+		// still running when Terminate gave up waiting
+		select {
+		case state := <-stateChan:
+			return state, ctx.Err()
+
+		default:
+			return nil, ctx.Err()
+		}
 
 	case err := <-errChan:
 		if err != nil {
@@ -95,7 +383,7 @@ func (c *Cloner) Run() error {
 			)
 		}
 
-		return err
+		return <-stateChan, err
 	}
 }
 
@@ -147,7 +435,17 @@ func (c *Cloner) cmdArgs() []string {
 		)
 	}
 
-	if cfg.Recursive {
+	args = append(
+		args, c.filterArgs()...,
+	)
+
+	if len(cfg.SparsePaths) > 0 {
+		args = append(
+			args, "--sparse",
+		)
+	}
+
+	if cfg.Recursive && !cfg.updateSubmodules() {
 		args = append(
 			args, "--recursive",
 		)
@@ -159,6 +457,12 @@ func (c *Cloner) cmdArgs() []string {
 		)
 	}
 
+	if c.mirror != "" {
+		args = append(
+			args, "--reference", c.mirror, "--dissociate",
+		)
+	}
+
 	args = append(
 		args,
 		cfg.RepoURL,
@@ -168,27 +472,103 @@ func (c *Cloner) cmdArgs() []string {
 	return args
 }
 
+func (c *Cloner) shallowFetchArgs() []string {
+	args := append(
+		[]string{"fetch", "--depth", "1"}, c.filterArgs()...,
+	)
+
+	return append(
+		args,
+		"origin",
+		fmt.Sprintf("+%s:%s", c.Cfg.target(), fetchedRef),
+	)
+}
+
+func (c *Cloner) fetchArgs() []string {
+	args := append(
+		[]string{"fetch", "--tags"}, c.filterArgs()...,
+	)
+
+	args = append(
+		args,
+		"origin",
+		"+refs/heads/*:refs/remotes/origin/*",
+	)
+
+	if c.Cfg.ref() != "" {
+		args = append(
+			args, fmt.Sprintf("+%s:%s", c.Cfg.ref(), fetchedRef),
+		)
+	}
+
+	return args
+}
+
+func (c *Cloner) filterArgs() []string {
+	if c.Cfg.Filter == "" {
+		return nil
+	}
+
+	return []string{"--filter=" + c.Cfg.Filter}
+}
+
+func (c *Cloner) sparseArgs() []string {
+	return append(
+		[]string{"sparse-checkout", "set"}, c.Cfg.SparsePaths...,
+	)
+}
+
+func (c *Cloner) checkoutArgs() []string {
+	target := c.Cfg.Commit
+
+	if target == "" {
+		target = fetchedRef
+	}
+
+	return []string{
+		"checkout", "--detach", target,
+	}
+}
+
 func (c *Cloner) gitSSHCommand() string {
+	args := []string{
+		"ssh",
+		"-v",
+	}
+
+	// written along with the known hosts, see writeSSHConfig
+	if c.Cfg.AcceptNewHostKeys && c.Cfg.SSHKeyDir != "" {
+		args = append(
+			args, fmt.Sprintf("-F %s", c.sshConfigPath()),
+		)
+	} else {
+		args = append(
+			args, "-o StrictHostKeyChecking=yes",
+		)
+	}
+
+	args = append(
+		args, fmt.Sprintf("-o UserKnownHostsFile=%s", c.knownHostsPath()),
+	)
+
+	// the agent holds the key otherwise
+	if c.agent == nil {
+		args = append(
+			args, fmt.Sprintf("-i %s/id", c.Cfg.SSHKeyDir),
+		)
+	}
+
 	return fmt.Sprintf(
 		"%s=%s",
 		"GIT_SSH_COMMAND",
-		strings.Join(
-			[]string{
-				"ssh",
-				"-v",
-				"-o StrictHostKeyChecking=no",
-				"-o UserKnownHostsFile=/dev/null",
-				fmt.Sprintf("-i %s/id", c.Cfg.SSHKeyDir),
-			},
-			" ",
-		),
+		strings.Join(args, " "),
 	)
 }
 
 func (c *Cloner) dumpCmd(cmd *exec.Cmd) {
 	l := c.Cfg.Logger
 
-	l.Info().Msgf("WD: %s", c.Cfg.WorkDir)
+	l.Info().Msgf("WD: %s", cmd.Dir)
 	l.Info().Msgf("ARGS: %q", cmd.Args)
 	l.Info().Msgf("ENV: %q", cmd.Env)
 }
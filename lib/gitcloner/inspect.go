@@ -0,0 +1,158 @@
+package gitcloner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CommitInfo describes the commit checked out.
+type CommitInfo struct {
+	SHA      string `json:"commit"`
+	ShortSHA string `json:"short_commit"`
+	Ref      string `json:"ref,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+
+	AuthorName     string `json:"author_name"`
+	AuthorEmail    string `json:"author_email"`
+	CommitterName  string `json:"committer_name"`
+	CommitterEmail string `json:"committer_email"`
+
+	Subject string    `json:"subject"`
+	Date    time.Time `json:"date"`
+
+	// submodule path to checked out SHA
+	Submodules map[string]string `json:"submodules,omitempty"`
+
+	LFS *LFSStats `json:"lfs,omitempty"`
+}
+
+// one field per line, the subject being the last one
+const logFormat = "%H%n%h%n%an%n%ae%n%cn%n%ce%n%cI%n%s"
+
+// inspect gathers the description of HEAD.
+func (c *Cloner) inspect(ctx context.Context) (*CommitInfo, error) {
+	out, err := c.output(
+		ctx, "log", "-1", "--format="+logFormat, "HEAD",
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the empty line of an empty subject is trimmed by output
+	fields := strings.SplitN(out, "\n", 8)
+	if len(fields) == 7 {
+		fields = append(fields, "")
+	}
+
+	if len(fields) != 8 {
+		return nil, fmt.Errorf("unexpected git log output: %q", out)
+	}
+
+	date, err := time.Parse(time.RFC3339, fields[6])
+	if err != nil {
+		return nil, err
+	}
+
+	info := &CommitInfo{
+		SHA:      fields[0],
+		ShortSHA: fields[1],
+		Ref:      c.Cfg.Ref,
+
+		AuthorName:     fields[2],
+		AuthorEmail:    fields[3],
+		CommitterName:  fields[4],
+		CommitterEmail: fields[5],
+
+		Subject: fields[7],
+		Date:    date,
+	}
+
+	info.Branch, err = c.branch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Cfg.Recursive {
+		info.Submodules, err = c.submodules(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// branch is the branch checked out, or the branch asked for when HEAD is
+// detached.
+func (c *Cloner) branch(ctx context.Context) (string, error) {
+	out, err := c.output(
+		ctx, "rev-parse", "--abbrev-ref", "HEAD",
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	if out != "HEAD" {
+		return out, nil
+	}
+
+	if c.Cfg.Branch != "" {
+		return c.Cfg.Branch, nil
+	}
+
+	if strings.HasPrefix(c.Cfg.Ref, "refs/heads/") {
+		return strings.TrimPrefix(c.Cfg.Ref, "refs/heads/"), nil
+	}
+
+	return "", nil
+}
+
+func (c *Cloner) submodules(ctx context.Context) (map[string]string, error) {
+	out, err := c.output(
+		ctx, "submodule", "status", "--recursive",
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	modules := map[string]string{}
+
+	for _, l := range strings.Split(out, "\n") {
+		// "<state><sha> <path> (<describe>)", uninitialized ones are
+		// prefixed with -
+		fields := strings.Fields(l)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+
+		modules[fields[1]] = strings.TrimLeft(fields[0], "+U")
+	}
+
+	if len(modules) == 0 {
+		return nil, nil
+	}
+
+	return modules, nil
+}
+
+// output runs a git command in the checkout directory and returns its
+// trimmed standard output.
+func (c *Cloner) output(ctx context.Context, args ...string) (string, error) {
+	buff := &bytes.Buffer{}
+
+	_, err := c.command(
+		ctx, c.Cfg.CheckoutDir, buff, args...,
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buff.String()), nil
+}
@@ -0,0 +1,176 @@
+package gitcloner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	knownHostsFile = "known_hosts"
+	sshConfigFile  = "ssh_config"
+
+	// longest line of ssh output looked at
+	maxWatchedLine = 4096
+)
+
+// pinnedKnownHosts are the host keys published by the main hosting services:
+//
+// - https://docs.github.com/en/authentication/keeping-your-account-and-data-secure/githubs-ssh-key-fingerprints
+// - https://docs.gitlab.com/ee/user/gitlab_com/#ssh-known_hosts-entries
+// - https://support.atlassian.com/bitbucket-cloud/docs/configure-ssh-and-two-step-verification/
+var pinnedKnownHosts = []string{
+	"github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl",
+	"github.com ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBEmKSENjQEezOmxkZMy7opKgwFB9nkt5YRrYMjNuG5N87uRgg6CLrbo5wAdT/y6v0mKV0U2w0WZ2YB/++Tpockg=",
+	"github.com ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQCj7ndNxQowgcQnjshcLrqPEiiphnt+VTTvDP6mHBL9j1aNUkY4Ue1gvwnGLVlOhGeYrnZaMgRK6+PKCUXaDbC7qtbW8gIkhL7aGCsOr/C56SJMy/BCZfxd1nWzAOxSDPgVsmerOBYfNqltV9/hWCqBywINIR+5dIg6JTJ72pcEpEjcYgXkE2YEFXV1JHnsKgbLWNlhScqb2UmyRkQyytRLtL+38TGxkxCflmO+5Z8CSSNY7GidjMIZ7Q4zMjA2n1nGrlTDkzwDCsw+wqFPGQA179cnfGWOWRVruj16z6XyvxvjJwbz0wQZ75XK5tKSb7FNyeIEs4TT4jk+S4dhPeAUC5y+bDYirYgM4GC7uEnztnZyaVWQ7B381AK4Qdrwt51ZqExKbQpTUNn+EjqoTwvqNj4kqx5QUCI0ThS/YkOxJCXmPUWZbhjpCg56i+2aB6CmK2JGhn57K5mj0MNdBXA4/WnwH6XoPWJzK5Nyu2zB3nAZp+S5hpQs+p1vN1/wsjk=",
+	"gitlab.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAfuCHKVTjquxvt6CM6tdG4SLp1Btn/nOeHHE5UOzRdf",
+	"bitbucket.org ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIazEu89wgQZ4bqs3d63QSMzYVa0MuJ2e2gKTKqu+UUO",
+}
+
+// HostKeyError is returned when the SSH host key of the git server could not
+// be verified, either unknown or changed.
+type HostKeyError struct {
+	Err error
+}
+
+func (e *HostKeyError) Error() string {
+	return "host key verification failed: " + e.Err.Error()
+}
+
+func (c *Cloner) knownHostsPath() string {
+	return filepath.Join(
+		c.Cfg.SSHKeyDir, knownHostsFile,
+	)
+}
+
+func (c *Cloner) sshConfigPath() string {
+	return filepath.Join(
+		c.Cfg.SSHKeyDir, sshConfigFile,
+	)
+}
+
+// writeKnownHosts writes the job known hosts after the pinned ones, next to
+// the secret key.
+func (c *Cloner) writeKnownHosts() error {
+	if c.Cfg.SSHKeyDir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(
+		c.Cfg.SSHKeyDir, 0700,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	lines := append(
+		[]string{}, pinnedKnownHosts...,
+	)
+
+	if c.Cfg.KnownHosts != "" {
+		lines = append(
+			lines, strings.TrimSpace(c.Cfg.KnownHosts),
+		)
+	}
+
+	err = ioutil.WriteFile(
+		c.knownHostsPath(),
+		[]byte(strings.Join(lines, "\n")+"\n"),
+		0600,
+	)
+
+	if err != nil || !c.Cfg.AcceptNewHostKeys {
+		return err
+	}
+
+	return c.writeSSHConfig(lines)
+}
+
+// writeSSHConfig has the keys of the hosts listed in known hosts lines
+// strictly checked, and the keys of other hosts accepted the first time
+// they are seen, see AcceptNewHostKeys.
+func (c *Cloner) writeSSHConfig(knownHosts []string) error {
+	config := fmt.Sprintf(
+		"Host %s\n\tStrictHostKeyChecking yes\n\nHost *\n\tStrictHostKeyChecking accept-new\n",
+		strings.Join(knownHostNames(knownHosts), " "),
+	)
+
+	return ioutil.WriteFile(
+		c.sshConfigPath(), []byte(config), 0600,
+	)
+}
+
+// knownHostNames returns the host names and patterns of known hosts lines.
+// Hashed names cannot be listed, their keys can still not change.
+func knownHostNames(lines []string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, l := range strings.Split(strings.Join(lines, "\n"), "\n") {
+		fields := strings.Fields(l)
+
+		// @cert-authority or @revoked
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+			fields = fields[1:]
+		}
+
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		for _, name := range strings.Split(fields[0], ",") {
+			// [host]:port
+			i := strings.Index(name, "]")
+			if strings.HasPrefix(name, "[") && i > 0 {
+				name = name[1:i]
+			}
+
+			if name == "" || seen[name] || strings.ContainsAny(name, "|!") {
+				continue
+			}
+
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// hostKeyWatcher spots ssh reporting a host key verification failure in the
+// output of git, line by line whatever the writes.
+type hostKeyWatcher struct {
+	failed bool
+	line   []byte
+}
+
+func (w *hostKeyWatcher) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+
+		w.check(w.line[:i])
+		w.line = w.line[i+1:]
+	}
+
+	if len(w.line) > maxWatchedLine {
+		w.check(w.line)
+		w.line = nil
+	}
+
+	return len(p), nil
+}
+
+func (w *hostKeyWatcher) check(line []byte) {
+	if bytes.Contains(line, []byte("Host key verification failed")) {
+		w.failed = true
+	}
+}
@@ -0,0 +1,147 @@
+package gitcloner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LFSStats describes the Git LFS objects of the checkout, and those
+// downloaded by git lfs pull, the objects already in the local LFS storage
+// being left out. Duration is in seconds.
+type LFSStats struct {
+	Files           int     `json:"files"`
+	Downloaded      int     `json:"downloaded"`
+	DownloadedBytes int64   `json:"downloaded_bytes"`
+	Duration        float64 `json:"duration"`
+}
+
+// checkLFS makes sure git-lfs can be run by git before anything is cloned.
+func (c *Cloner) checkLFS(ctx context.Context) error {
+	_, err := c.command(
+		ctx, c.Cfg.WorkDir, ioutil.Discard, "lfs", "version",
+	)
+
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("git_lfs: git-lfs is not installed (%s)", err)
+	}
+
+	return err
+}
+
+// lfsPull replaces the pointer files left by the clone with their contents.
+func (c *Cloner) lfsPull(ctx context.Context) error {
+	start := time.Now()
+
+	err := c.git(ctx, "lfs", "install", "--local")
+	if err != nil {
+		return err
+	}
+
+	before, err := c.lfsObjects()
+	if err != nil {
+		return err
+	}
+
+	err = c.git(ctx, c.lfsPullArgs()...)
+	if err != nil {
+		return err
+	}
+
+	after, err := c.lfsObjects()
+	if err != nil {
+		return err
+	}
+
+	stats, err := c.lfsStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	for oid, size := range after {
+		if _, ok := before[oid]; !ok {
+			stats.Downloaded++
+			stats.DownloadedBytes += size
+		}
+	}
+
+	stats.Duration = time.Since(start).Seconds()
+
+	c.lfs = stats
+
+	return nil
+}
+
+func (c *Cloner) lfsPullArgs() []string {
+	args := []string{
+		"lfs", "pull",
+	}
+
+	if len(c.Cfg.LFSInclude) > 0 {
+		args = append(
+			args, "--include="+strings.Join(c.Cfg.LFSInclude, ","),
+		)
+	}
+
+	if len(c.Cfg.LFSExclude) > 0 {
+		args = append(
+			args, "--exclude="+strings.Join(c.Cfg.LFSExclude, ","),
+		)
+	}
+
+	return args
+}
+
+// lfsStats counts the LFS files of the checkout.
+func (c *Cloner) lfsStats(ctx context.Context) (*LFSStats, error) {
+	out, err := c.output(
+		ctx, "lfs", "ls-files", "--long",
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &LFSStats{}
+
+	for _, l := range strings.Split(out, "\n") {
+		// "<oid> <*|-> <path>"
+		if len(strings.SplitN(l, " ", 3)) == 3 {
+			stats.Files++
+		}
+	}
+
+	return stats, nil
+}
+
+// lfsObjects maps the oid of the objects in the local LFS storage to their
+// size.
+func (c *Cloner) lfsObjects() (map[string]int64, error) {
+	objects := map[string]int64{}
+
+	err := filepath.Walk(
+		filepath.Join(c.Cfg.CheckoutDir, ".git", "lfs", "objects"),
+		func(_ string, info os.FileInfo, err error) error {
+			// nothing stored yet
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if info.Mode().IsRegular() {
+				objects[info.Name()] = info.Size()
+			}
+
+			return nil
+		},
+	)
+
+	return objects, err
+}
@@ -0,0 +1,108 @@
+package gitcloner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const credentialHelperFile = "git-credential-helper"
+
+// SubmoduleCredentials are used for the submodules which URL starts with
+// URL, over HTTP(S).
+type SubmoduleCredentials struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// updateSubmodules tells whether submodules are updated on their own, once
+// checked out, rather than by git clone --recursive.
+func (c *Config) updateSubmodules() bool {
+	if !c.Recursive {
+		return false
+	}
+
+	return c.Ref != "" || c.Commit != "" ||
+		len(c.SubmodulePaths) > 0 || c.SubmoduleDepth > 0
+}
+
+func (c *Cloner) submoduleArgs() []string {
+	args := []string{
+		"submodule", "update", "--init", "--recursive",
+	}
+
+	if c.Cfg.SubmoduleDepth > 0 {
+		args = append(
+			args, "--depth", strconv.Itoa(c.Cfg.SubmoduleDepth),
+		)
+	}
+
+	if len(c.Cfg.SubmodulePaths) > 0 {
+		args = append(args, "--")
+		args = append(args, c.Cfg.SubmodulePaths...)
+	}
+
+	return args
+}
+
+func (c *Cloner) credentialHelperPath() string {
+	return filepath.Join(
+		c.Cfg.WorkDir, credentialHelperFile,
+	)
+}
+
+// configArgs point git to the credential helper, which answers for the
+// submodules with their own credentials. Other requests fall through to
+// GIT_ASKPASS.
+func (c *Cloner) configArgs() []string {
+	if len(c.Cfg.SubmoduleCredentials) == 0 {
+		return nil
+	}
+
+	return []string{
+		"-c", "credential.helper=" + c.credentialHelperPath(),
+		"-c", "credential.useHttpPath=true",
+	}
+}
+
+func (c *Cloner) writeCredentialHelper() error {
+	lines := []string{
+		"#!/bin/sh",
+		`[ "$1" = get ] || exit 0`,
+		"while read -r line; do",
+		`	[ -z "$line" ] && break`,
+		`	case "$line" in`,
+		`	protocol=*) protocol=${line#protocol=} ;;`,
+		`	host=*) host=${line#host=} ;;`,
+		`	path=*) path=${line#path=} ;;`,
+		"	esac",
+		"done",
+		`case "$protocol://$host/$path" in`,
+	}
+
+	for _, sc := range c.Cfg.SubmoduleCredentials {
+		username := sc.Username
+
+		if username == "" {
+			username = defaultUsername
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"%s*) echo %s; echo %s ;;",
+			shellQuote(sc.URL),
+			shellQuote("username="+username),
+			shellQuote("password="+sc.Token),
+		))
+	}
+
+	lines = append(lines, "esac")
+
+	return ioutil.WriteFile(
+		c.credentialHelperPath(),
+		[]byte(strings.Join(lines, "\n")+"\n"),
+		0700,
+	)
+}
@@ -0,0 +1,50 @@
+package logstream
+
+import (
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+)
+
+const (
+	defaultInterval = 5 * time.Second
+	defaultMaxBytes = 64 * 1024
+	defaultTimeout  = 5 * time.Second
+
+	// chunks buffered when not specified
+	defaultBufferedChunks = 4
+)
+
+type Config struct {
+	URLs []string `json:"log_stream_callbacks"`
+
+	// A chunk is sent every Interval, or as soon as MaxBytes of logs are
+	// waiting, whichever comes first.
+	Interval duration.Duration `json:"log_stream_interval"`
+	MaxBytes int               `json:"log_stream_max_bytes"`
+
+	// Logs waiting beyond MaxBuffer bytes, when the callbacks cannot keep
+	// up, are dropped by chunks
+	MaxBuffer int `json:"log_stream_max_buffer"`
+
+	// Maximum time spent sending a chunk to a callback, retries included
+	Timeout duration.Duration `json:"log_stream_timeout"`
+}
+
+func (c *Config) setDefaults() {
+	if c.Interval.Duration == 0 {
+		c.Interval.Duration = defaultInterval
+	}
+
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaultMaxBytes
+	}
+
+	if c.MaxBuffer < c.MaxBytes {
+		c.MaxBuffer = defaultBufferedChunks * c.MaxBytes
+	}
+
+	if c.Timeout.Duration == 0 {
+		c.Timeout.Duration = defaultTimeout
+	}
+}
@@ -0,0 +1,211 @@
+package logstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/notifier"
+)
+
+type Chunk struct {
+	Sequence int       `json:"sequence"`
+	Time     time.Time `json:"time"`
+	Output   string    `json:"output"`
+	Final    bool      `json:"final"`
+}
+
+// Streamer is an io.Writer buffering the build logs and sending them by
+// chunks to the log stream callbacks while the build runs.
+type Streamer struct {
+	Cfg *Config
+
+	notifier *notifier.Notifier
+
+	buff     bytes.Buffer
+	sequence int
+	dropped  int
+	m        sync.Mutex
+
+	started bool
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func New(cfg *Config, n *notifier.Notifier) *Streamer {
+	cfg.setDefaults()
+
+	return &Streamer{
+		Cfg: cfg,
+
+		notifier: n,
+
+		full: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (s *Streamer) Write(p []byte) (int, error) {
+	s.m.Lock()
+	s.buff.Write(p)
+
+	// a dropped chunk shows up as a gap in the sequence numbers
+	for s.buff.Len() > s.Cfg.MaxBuffer {
+		s.pop()
+		s.sequence++
+		s.dropped++
+	}
+
+	full := s.buff.Len() >= s.Cfg.MaxBytes
+	s.m.Unlock()
+
+	if full {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *Streamer) Start() {
+	s.started = true
+
+	go s.loop()
+}
+
+// Close sends the remaining logs in a final chunk.
+func (s *Streamer) Close() error {
+	if !s.started {
+		s.flush(true)
+		return nil
+	}
+
+	close(s.stop)
+	<-s.done
+
+	return nil
+}
+
+// ----
+
+func (s *Streamer) loop() {
+	defer close(s.done)
+
+	t := time.NewTicker(s.Cfg.Interval.Duration)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.flush(false)
+
+		case <-s.full:
+			s.flush(false)
+
+		case <-s.stop:
+			s.flush(true)
+			return
+		}
+	}
+}
+
+// flush sends the buffered logs. A callback failing is skipped for the rest
+// of the flush, so that it holds back the build for Timeout at most.
+func (s *Streamer) flush(final bool) {
+	failed := map[string]bool{}
+
+	for {
+		c, remaining := s.next(final)
+
+		if c == nil {
+			return
+		}
+
+		s.send(c, failed)
+
+		if remaining == 0 {
+			return
+		}
+	}
+}
+
+// next returns the next chunk, nil when there is nothing to send.
+func (s *Streamer) next(final bool) (*Chunk, int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.dropped > 0 {
+		log.Printf("Log stream: %d chunks dropped", s.dropped)
+		s.dropped = 0
+	}
+
+	data := s.pop()
+	remaining := s.buff.Len()
+
+	if len(data) == 0 && !final {
+		return nil, remaining
+	}
+
+	s.sequence++
+
+	return &Chunk{
+		Sequence: s.sequence - 1,
+		Time:     time.Now().UTC(),
+		Output:   string(data),
+		Final:    final && remaining == 0,
+	}, remaining
+}
+
+// pop takes at most MaxBytes from the buffer, cutting on a line boundary
+// whenever possible, s.m must be held.
+func (s *Streamer) pop() []byte {
+	n := s.buff.Len()
+
+	if n > s.Cfg.MaxBytes {
+		n = s.Cfg.MaxBytes
+
+		i := bytes.LastIndexByte(s.buff.Bytes()[:n], '\n')
+		if i >= 0 {
+			n = i + 1
+		}
+	}
+
+	data := make([]byte, n)
+	copy(data, s.buff.Next(n))
+
+	return data
+}
+
+func (s *Streamer) send(c *Chunk, failed map[string]bool) {
+	buff, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("Log stream: %s", err)
+		return
+	}
+
+	// a lost chunk shows up as a gap in the sequence numbers
+	for _, url := range s.Cfg.URLs {
+		if failed[url] {
+			continue
+		}
+
+		ctx, cancelFunc := context.WithTimeout(
+			context.Background(), s.Cfg.Timeout.Duration,
+		)
+
+		err := s.notifier.DeliverContext(ctx, url, buff)
+		cancelFunc()
+
+		if err != nil {
+			failed[url] = true
+			log.Printf("Log stream: %s", err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package logstream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/notifier"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamer(t *testing.T) {
+	testFuncs := map[string]func(*testing.T){
+		"close without start": testCloseWithoutStart,
+		"flush on size":       testFlushOnSize,
+		"flush on interval":   testFlushOnInterval,
+		"line boundaries":     testLineBoundaries,
+		"bounded buffer":      testBoundedBuffer,
+		"slow callback":       testSlowCallback,
+	}
+
+	for desc, f := range testFuncs {
+		t.Run(desc, f)
+	}
+}
+
+func testCloseWithoutStart(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	s := newTestStreamer(r.URL, time.Hour, 1024)
+
+	s.Write([]byte("a\n"))
+	s.Write([]byte("b\n"))
+
+	err := s.Close()
+	require.Nil(t, err)
+
+	chunks := r.received()
+	require.Len(t, chunks, 1)
+
+	require.Equal(t, 0, chunks[0].Sequence)
+	require.Equal(t, "a\nb\n", chunks[0].Output)
+	require.True(t, chunks[0].Final)
+}
+
+func testFlushOnSize(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	s := newTestStreamer(r.URL, time.Hour, 4)
+	s.Start()
+
+	s.Write([]byte("abc\n"))
+
+	waitForChunks(t, r, 1)
+
+	s.Write([]byte("d\n"))
+	s.Close()
+
+	chunks := r.received()
+	require.Len(t, chunks, 2)
+
+	require.Equal(t, "abc\n", chunks[0].Output)
+	require.False(t, chunks[0].Final)
+
+	require.Equal(t, 1, chunks[1].Sequence)
+	require.Equal(t, "d\n", chunks[1].Output)
+	require.True(t, chunks[1].Final)
+}
+
+func testFlushOnInterval(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	s := newTestStreamer(r.URL, 20*time.Millisecond, 1024)
+	s.Start()
+
+	s.Write([]byte("a\n"))
+
+	waitForChunks(t, r, 1)
+
+	s.Close()
+
+	chunks := r.received()
+	require.Len(t, chunks, 2)
+
+	require.Equal(t, "a\n", chunks[0].Output)
+	require.Equal(t, "", chunks[1].Output)
+	require.True(t, chunks[1].Final)
+}
+
+func testLineBoundaries(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	s := newTestStreamer(r.URL, time.Hour, 8)
+
+	s.Write([]byte("aaa\nbbb\ncccccccccc\n"))
+	s.Close()
+
+	outputs := []string{}
+
+	for i, c := range r.received() {
+		require.Equal(t, i, c.Sequence)
+		outputs = append(outputs, c.Output)
+	}
+
+	require.Equal(t,
+		[]string{"aaa\nbbb\n", "cccccccc", "cc\n"},
+		outputs,
+	)
+
+	require.Equal(t,
+		"aaa\nbbb\ncccccccccc\n",
+		strings.Join(outputs, ""),
+	)
+}
+
+func testBoundedBuffer(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	s := newTestStreamer(r.URL, time.Hour, 4)
+	s.Cfg.MaxBuffer = 8
+
+	s.Write([]byte("aaa\nbbb\nccc\nddd\n"))
+	s.Close()
+
+	chunks := r.received()
+	require.Len(t, chunks, 2)
+
+	// the oldest chunks are dropped
+	require.Equal(t, 2, chunks[0].Sequence)
+	require.Equal(t, "ccc\n", chunks[0].Output)
+
+	require.Equal(t, 3, chunks[1].Sequence)
+	require.True(t, chunks[1].Final)
+}
+
+func testSlowCallback(t *testing.T) {
+	release := make(chan struct{})
+
+	slow := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			select {
+			case <-release:
+			case <-req.Context().Done():
+			}
+		}),
+	)
+	defer slow.Close()
+	defer close(release)
+
+	r := newReceiver()
+	defer r.Close()
+
+	s := newTestStreamer(slow.URL, time.Hour, 4)
+	s.Cfg.URLs = append(s.Cfg.URLs, r.URL)
+	s.Cfg.Timeout.Duration = 50 * time.Millisecond
+
+	s.Write([]byte("aaa\nbbb\nccc\n"))
+
+	start := time.Now()
+	s.Close()
+
+	// the slow callback is given up on after the first chunk
+	require.True(t, time.Since(start) < time.Second)
+	require.Len(t, r.received(), 3)
+}
+
+// ----
+
+type receiver struct {
+	*httptest.Server
+
+	chunks []*Chunk
+	m      sync.Mutex
+}
+
+func newReceiver() *receiver {
+	r := new(receiver)
+
+	r.Server = httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			c := new(Chunk)
+			json.NewDecoder(req.Body).Decode(c)
+
+			r.m.Lock()
+			r.chunks = append(r.chunks, c)
+			r.m.Unlock()
+		}),
+	)
+
+	return r
+}
+
+func (r *receiver) received() []*Chunk {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]*Chunk{}, r.chunks...)
+}
+
+func waitForChunks(t *testing.T, r *receiver, n int) {
+	for i := 0; i < 100; i++ {
+		if len(r.received()) >= n {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Fail(t, "chunks not received")
+}
+
+func newTestStreamer(url string, interval time.Duration, max int) *Streamer {
+	n := notifier.New(
+		context.TODO(), &notifier.Config{MaxAttempts: 1},
+	)
+
+	// defaults filled by New, tests override them afterwards
+	return New(
+		&Config{
+			URLs:     []string{url},
+			Interval: duration.Duration{Duration: interval},
+			MaxBytes: max,
+		},
+		n,
+	)
+}
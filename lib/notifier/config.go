@@ -0,0 +1,60 @@
+package notifier
+
+import (
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/redact"
+)
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	defaultTimeout        = 30 * time.Second
+	defaultReplayTimeout  = time.Minute
+)
+
+type Config struct {
+	MaxAttempts    int               `json:"callback_max_attempts"`
+	InitialBackoff duration.Duration `json:"callback_initial_backoff"`
+	MaxBackoff     duration.Duration `json:"callback_max_backoff"`
+	Timeout        duration.Duration `json:"callback_timeout"`
+
+	// When set, payloads are signed with HMAC-SHA256, see lib/signature.
+	Secret redact.Secret `json:"callback_secret"`
+
+	// Payloads that could not be delivered are kept here and replayed by
+	// the next invocation using the same directory.
+	OutboxDir string `json:"callback_outbox_dir"`
+
+	// Maximum time spent replaying the outbox before the result of the
+	// build is sent, the entries left are replayed by the next invocation.
+	ReplayTimeout duration.Duration `json:"callback_outbox_replay_timeout"`
+}
+
+func (c *Config) setDefaults() {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultMaxAttempts
+	}
+
+	if c.InitialBackoff.Duration == 0 {
+		c.InitialBackoff.Duration = defaultInitialBackoff
+	}
+
+	if c.MaxBackoff.Duration == 0 {
+		c.MaxBackoff.Duration = defaultMaxBackoff
+	}
+
+	if c.MaxBackoff.Duration < c.InitialBackoff.Duration {
+		c.MaxBackoff.Duration = c.InitialBackoff.Duration
+	}
+
+	if c.Timeout.Duration == 0 {
+		c.Timeout.Duration = defaultTimeout
+	}
+
+	if c.ReplayTimeout.Duration == 0 {
+		c.ReplayTimeout.Duration = defaultReplayTimeout
+	}
+}
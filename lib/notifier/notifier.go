@@ -0,0 +1,189 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/signature"
+)
+
+type Notifier struct {
+	Cfg *Config
+
+	client *http.Client
+	rand   *rand.Rand
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func New(ctx context.Context, cfg *Config) *Notifier {
+	ctx2, cancelFunc := context.WithCancel(ctx)
+
+	cfg.setDefaults()
+
+	return &Notifier{
+		Cfg: cfg,
+
+		client: &http.Client{
+			Timeout: cfg.Timeout.Duration,
+		},
+		rand: rand.New(
+			rand.NewSource(time.Now().UnixNano()),
+		),
+
+		ctx:        ctx2,
+		cancelFunc: cancelFunc,
+	}
+}
+
+// Notify delivers body to every URL independently. Payloads that still
+// cannot be delivered once retries are exhausted are moved to the outbox.
+func (n *Notifier) Notify(urls []string, body []byte) error {
+	errs := []string{}
+
+	for _, url := range urls {
+		err := n.Deliver(url, body)
+		if err == nil {
+			continue
+		}
+
+		errs = append(errs, err.Error())
+
+		err = n.writeOutbox(url, body)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// Deliver posts body to url, retrying with exponential backoff and jitter
+// until a 2xx response is received or MaxAttempts is reached.
+func (n *Notifier) Deliver(url string, body []byte) error {
+	return n.deliver(n.ctx, url, body)
+}
+
+// DeliverContext is Deliver giving up once ctx is done.
+func (n *Notifier) DeliverContext(ctx context.Context, url string, body []byte) error {
+	return n.deliver(ctx, url, body)
+}
+
+// Post makes a single delivery attempt.
+func (n *Notifier) Post(url string, body []byte) error {
+	return n.post(n.ctx, url, body)
+}
+
+// ----
+
+func (n *Notifier) deliver(ctx context.Context, url string, body []byte) error {
+	var err error
+
+	for attempt := 0; attempt < n.Cfg.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			err2 := sleep(ctx, n.backoff(attempt))
+			if err2 != nil {
+				return fmt.Errorf("callback %s: %s (%s)", url, err, err2)
+			}
+		}
+
+		err = n.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"callback %s: giving up after %d attempts: %s",
+		url, n.Cfg.MaxAttempts, err,
+	)
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequest(
+		http.MethodPost,
+		url,
+		bytes.NewReader(body),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	// signed for every attempt, each one gets its own timestamp and nonce
+	if n.Cfg.Secret != "" {
+		err = signature.SignRequest(
+			req, []byte(n.Cfg.Secret), body,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %q", resp.Status)
+	}
+
+	return nil
+}
+
+// backoff returns a delay picked in [d/2, d] where d doubles at every
+// attempt, starting from InitialBackoff and capped to MaxBackoff.
+func (n *Notifier) backoff(attempt int) time.Duration {
+	d := n.Cfg.InitialBackoff.Duration
+
+	for i := 1; i < attempt && d < n.Cfg.MaxBackoff.Duration; i++ {
+		d *= 2
+	}
+
+	if d > n.Cfg.MaxBackoff.Duration {
+		d = n.Cfg.MaxBackoff.Duration
+	}
+
+	half := int64(d / 2)
+
+	return time.Duration(half + n.rand.Int63n(half+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	case <-t.C:
+		return nil
+	}
+}
+
+func joinErrors(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"%s", strings.Join(errs, "; "),
+	)
+}
@@ -0,0 +1,391 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/redact"
+	"github.com/squarescale/simple-builder/lib/signature"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	tmpDir string
+)
+
+func TestNotifier(t *testing.T) {
+	testFuncs := map[string]func(*testing.T){
+		"deliver success":      testDeliverSuccess,
+		"deliver retries":      testDeliverRetries,
+		"deliver gives up":     testDeliverGivesUp,
+		"notify independently": testNotifyIndependently,
+		"outbox replay":        testOutboxReplay,
+		"outbox stale claim":   testOutboxStaleClaim,
+		"outbox bad entry":     testOutboxBadEntry,
+		"outbox replay time":   testOutboxReplayTimeout,
+		"outbox signed replay": testOutboxSignedReplay,
+		"backoff":              testBackoff,
+		"signed payloads":      testSignedPayloads,
+		"secret not marshaled": testSecretNotMarshaled,
+	}
+
+	for desc, f := range testFuncs {
+		setUp(t)
+		t.Run(desc, f)
+		tearDown(t)
+	}
+}
+
+func setUp(t *testing.T) {
+	d, err := ioutil.TempDir(
+		"", "notifiertestsuite",
+	)
+
+	require.Nil(t, err)
+
+	tmpDir = d
+}
+
+func tearDown(t *testing.T) {
+	err := os.RemoveAll(tmpDir)
+	require.Nil(t, err)
+}
+
+func testDeliverSuccess(t *testing.T) {
+	srv := newTestServer(0)
+	defer srv.Close()
+
+	n := newTestNotifier(3)
+
+	err := n.Deliver(srv.URL, []byte(`{"a":1}`))
+	require.Nil(t, err)
+
+	require.Equal(t, 1, srv.calls())
+	require.Equal(t, []string{`{"a":1}`}, srv.bodies)
+}
+
+func testDeliverRetries(t *testing.T) {
+	srv := newTestServer(2)
+	defer srv.Close()
+
+	n := newTestNotifier(3)
+
+	err := n.Deliver(srv.URL, []byte("{}"))
+	require.Nil(t, err)
+
+	require.Equal(t, 3, srv.calls())
+}
+
+func testDeliverGivesUp(t *testing.T) {
+	srv := newTestServer(10)
+	defer srv.Close()
+
+	n := newTestNotifier(3)
+
+	err := n.Deliver(srv.URL, []byte("{}"))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "500")
+
+	require.Equal(t, 3, srv.calls())
+}
+
+func testNotifyIndependently(t *testing.T) {
+	srv := newTestServer(0)
+	defer srv.Close()
+
+	n := newTestNotifier(2)
+
+	err := n.Notify(
+		[]string{"http://127.0.0.1:1/unreachable", srv.URL},
+		[]byte("{}"),
+	)
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "127.0.0.1:1")
+
+	require.Equal(t, 1, srv.calls())
+}
+
+func testOutboxReplay(t *testing.T) {
+	srv := newTestServer(2)
+	defer srv.Close()
+
+	n := newTestNotifier(2)
+	n.Cfg.OutboxDir = tmpDir
+
+	err := n.Notify(
+		[]string{srv.URL}, []byte(`{"b":2}`),
+	)
+	require.NotNil(t, err)
+
+	names, err := n.outboxEntries()
+	require.Nil(t, err)
+	require.Len(t, names, 1)
+
+	// ---
+
+	n2 := newTestNotifier(1)
+	n2.Cfg.OutboxDir = tmpDir
+
+	err = n2.Replay()
+	require.Nil(t, err)
+
+	names, err = n2.outboxEntries()
+	require.Nil(t, err)
+	require.Empty(t, names)
+
+	require.Equal(t, 3, srv.calls())
+	require.Equal(t, `{"b":2}`, srv.bodies[2])
+}
+
+func testOutboxStaleClaim(t *testing.T) {
+	srv := newTestServer(0)
+	defer srv.Close()
+
+	n := newTestNotifier(1)
+	n.Cfg.OutboxDir = tmpDir
+
+	err := n.writeOutbox(srv.URL, []byte(`{"b":1}`))
+	require.Nil(t, err)
+
+	names, err := n.outboxEntries()
+	require.Nil(t, err)
+	require.Len(t, names, 1)
+
+	// claimed by an invocation which died, recently and long ago
+	claimed := names[0] + outboxClaimExt
+
+	err = os.Rename(names[0], claimed)
+	require.Nil(t, err)
+
+	err = n.Replay()
+	require.Nil(t, err)
+	require.Equal(t, 0, srv.calls())
+
+	old := time.Now().Add(-2 * outboxClaimTTL)
+
+	err = os.Chtimes(claimed, old, old)
+	require.Nil(t, err)
+
+	err = n.Replay()
+	require.Nil(t, err)
+	require.Equal(t, 1, srv.calls())
+
+	_, err = os.Stat(claimed)
+	require.True(t, os.IsNotExist(err))
+}
+
+func testOutboxBadEntry(t *testing.T) {
+	n := newTestNotifier(1)
+	n.Cfg.OutboxDir = tmpDir
+
+	name := filepath.Join(tmpDir, "1-00000000"+outboxExt)
+
+	err := ioutil.WriteFile(name, []byte("{"), 0600)
+	require.Nil(t, err)
+
+	err = n.Replay()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "set aside")
+
+	require.FileExists(t, name+outboxBadExt)
+
+	// not retried
+	err = n.Replay()
+	require.Nil(t, err)
+}
+
+func testOutboxReplayTimeout(t *testing.T) {
+	srv := newTestServer(1000)
+	defer srv.Close()
+
+	n := newTestNotifier(1000)
+	n.Cfg.OutboxDir = tmpDir
+	n.Cfg.ReplayTimeout.Duration = 100 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		err := n.writeOutbox(srv.URL, []byte(`{}`))
+		require.Nil(t, err)
+	}
+
+	start := time.Now()
+
+	err := n.Replay()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "2 entries left")
+
+	require.True(t, time.Since(start) < 5*time.Second)
+
+	names, err := n.outboxEntries()
+	require.Nil(t, err)
+	require.Len(t, names, 3)
+}
+
+func testOutboxSignedReplay(t *testing.T) {
+	v := signature.NewVerifier([]byte("s3cr3t"), 0)
+	verified := 0
+
+	srv := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := v.VerifyRequest(r)
+			require.Nil(t, err)
+
+			verified++
+		}),
+	)
+	defer srv.Close()
+
+	n := newTestNotifier(1)
+	n.Cfg.OutboxDir = tmpDir
+	n.Cfg.Secret = "s3cr3t"
+
+	err := n.writeOutbox(srv.URL, []byte(`{}`))
+	require.Nil(t, err)
+
+	// neither signed with another secret nor sent unsigned
+	for _, secret := range []redact.Secret{"other", ""} {
+		n2 := newTestNotifier(1)
+		n2.Cfg.OutboxDir = tmpDir
+		n2.Cfg.Secret = secret
+
+		err = n2.Replay()
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "another callback_secret")
+	}
+
+	require.Equal(t, 0, verified)
+
+	err = n.Replay()
+	require.Nil(t, err)
+	require.Equal(t, 1, verified)
+
+	names, err := n.outboxEntries()
+	require.Nil(t, err)
+	require.Empty(t, names)
+}
+
+func testBackoff(t *testing.T) {
+	n := New(context.TODO(), &Config{
+		InitialBackoff: duration.Duration{Duration: time.Second},
+		MaxBackoff:     duration.Duration{Duration: 3 * time.Second},
+	})
+
+	for i := 0; i < 20; i++ {
+		d := n.backoff(1)
+		require.True(t, d >= 500*time.Millisecond && d <= time.Second)
+
+		d = n.backoff(2)
+		require.True(t, d >= time.Second && d <= 2*time.Second)
+
+		d = n.backoff(10)
+		require.True(t, d >= 1500*time.Millisecond && d <= 3*time.Second)
+	}
+}
+
+func testSignedPayloads(t *testing.T) {
+	v := signature.NewVerifier([]byte("s3cr3t"), 0)
+	verified := 0
+
+	srv := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := v.VerifyRequest(r)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			verified++
+		}),
+	)
+	defer srv.Close()
+
+	n := newTestNotifier(1)
+
+	err := n.Deliver(srv.URL, []byte("{}"))
+	require.NotNil(t, err)
+
+	n.Cfg.Secret = "s3cr3t"
+
+	err = n.Deliver(srv.URL, []byte("{}"))
+	require.Nil(t, err)
+
+	err = n.Deliver(srv.URL, []byte("{}"))
+	require.Nil(t, err)
+
+	require.Equal(t, 2, verified)
+}
+
+func testSecretNotMarshaled(t *testing.T) {
+	buff, err := json.Marshal(&Config{
+		Secret:    "s3cr3t",
+		OutboxDir: "outbox",
+	})
+
+	require.Nil(t, err)
+	require.NotContains(t, string(buff), "s3cr3t")
+	require.Contains(t, string(buff), "outbox")
+}
+
+// ----
+
+type testServer struct {
+	*httptest.Server
+
+	failures int
+	bodies   []string
+
+	m sync.Mutex
+}
+
+// newTestServer returns a server answering 500 to the first failures
+// requests and 200 afterwards.
+func newTestServer(failures int) *testServer {
+	s := &testServer{
+		failures: failures,
+	}
+
+	s.Server = httptest.NewServer(
+		http.HandlerFunc(s.handle),
+	)
+
+	return s
+}
+
+func (s *testServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	buff, _ := ioutil.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(buff))
+
+	if len(s.bodies) <= s.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *testServer) calls() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return len(s.bodies)
+}
+
+func newTestNotifier(attempts int) *Notifier {
+	return New(context.TODO(), &Config{
+		MaxAttempts:    attempts,
+		InitialBackoff: duration.Duration{Duration: time.Millisecond},
+		MaxBackoff:     duration.Duration{Duration: 2 * time.Millisecond},
+	})
+}
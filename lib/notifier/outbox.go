@@ -0,0 +1,231 @@
+package notifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	outboxExt      = ".json"
+	outboxClaimExt = ".inflight"
+	outboxBadExt   = ".bad"
+
+	// claims older than this are left over by an invocation which died
+	// while replaying them, the replay being capped well below
+	outboxClaimTTL = time.Hour
+)
+
+type outboxEntry struct {
+	URL       string    `json:"url"`
+	Body      []byte    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Identifies the callback_secret the payload was meant to be signed
+	// with, empty when unsigned. Signatures expire, payloads are signed
+	// when they are replayed, only by jobs sharing that secret.
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// Replay tries to deliver the payloads found in the outbox within
+// ReplayTimeout. Delivered entries are removed, the others are left in place
+// for the next run, but for unreadable ones which are set aside with a .bad
+// extension.
+func (n *Notifier) Replay() error {
+	if n.Cfg.OutboxDir == "" {
+		return nil
+	}
+
+	n.reclaimStale()
+
+	names, err := n.outboxEntries()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancelFunc := context.WithTimeout(
+		n.ctx, n.Cfg.ReplayTimeout.Duration,
+	)
+	defer cancelFunc()
+
+	errs := []string{}
+
+	for i, name := range names {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Sprintf(
+				"replay timeout, %d entries left for the next run",
+				len(names)-i,
+			))
+
+			break
+		}
+
+		err := n.replayEntry(ctx, name)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	return joinErrors(errs)
+}
+
+// ----
+
+func (n *Notifier) writeOutbox(url string, body []byte) error {
+	if n.Cfg.OutboxDir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(n.Cfg.OutboxDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	buff, err := json.Marshal(&outboxEntry{
+		URL:       url,
+		Body:      body,
+		CreatedAt: time.Now().UTC(),
+		KeyID:     keyID(string(n.Cfg.Secret)),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(
+		n.Cfg.OutboxDir,
+		fmt.Sprintf(
+			"%d-%08x%s",
+			time.Now().UnixNano(),
+			n.rand.Uint32(),
+			outboxExt,
+		),
+	)
+
+	// written aside then renamed so that a concurrent replay never reads a
+	// partial entry
+	tmp := name + ".tmp"
+
+	err = ioutil.WriteFile(tmp, buff, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+func (n *Notifier) outboxEntries() ([]string, error) {
+	return n.outboxFiles(outboxExt, 0)
+}
+
+// outboxFiles returns the outbox files with the given extension which were
+// last modified more than minAge ago.
+func (n *Notifier) outboxFiles(ext string, minAge time.Duration) ([]string, error) {
+	infos, err := ioutil.ReadDir(n.Cfg.OutboxDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ext) {
+			continue
+		}
+
+		if time.Since(info.ModTime()) < minAge {
+			continue
+		}
+
+		names = append(
+			names,
+			filepath.Join(n.Cfg.OutboxDir, info.Name()),
+		)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// reclaimStale puts back the entries claimed by an invocation which did not
+// finish replaying them.
+func (n *Notifier) reclaimStale() {
+	names, err := n.outboxFiles(outboxClaimExt, outboxClaimTTL)
+	if err != nil {
+		return
+	}
+
+	for _, claimed := range names {
+		os.Rename(
+			claimed, strings.TrimSuffix(claimed, outboxClaimExt),
+		)
+	}
+}
+
+func (n *Notifier) replayEntry(ctx context.Context, name string) error {
+	claimed := name + outboxClaimExt
+
+	// another invocation sharing the outbox may be replaying it already
+	err := os.Rename(name, claimed)
+	if err != nil {
+		return nil
+	}
+
+	// the age of the claim, not of the entry
+	now := time.Now()
+	os.Chtimes(claimed, now, now)
+
+	buff, err := ioutil.ReadFile(claimed)
+	if err != nil {
+		os.Rename(claimed, name)
+		return err
+	}
+
+	e := new(outboxEntry)
+
+	err = json.Unmarshal(buff, e)
+	if err != nil {
+		os.Rename(claimed, name+outboxBadExt)
+		return fmt.Errorf("outbox entry %s: %s, set aside", name, err)
+	}
+
+	// left for an invocation able to sign it
+	if e.KeyID != keyID(string(n.Cfg.Secret)) {
+		os.Rename(claimed, name)
+		return fmt.Errorf("outbox entry %s: written for another callback_secret", name)
+	}
+
+	err = n.deliver(ctx, e.URL, e.Body)
+	if err != nil {
+		os.Rename(claimed, name)
+		return err
+	}
+
+	return os.Remove(claimed)
+}
+
+// keyID returns an identifier of secret which does not disclose it.
+func keyID(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("simple-builder outbox key id"))
+
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,159 @@
+package procgroup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultGracePeriod = 10 * time.Second
+
+	// how long to wait for the command once SIGKILL has been sent
+	killWait = 5 * time.Second
+
+	pollInterval = 100 * time.Millisecond
+)
+
+// Termination records how a process group was stopped.
+type Termination struct {
+	// signals sent to the process group, in order
+	Signals     []string `json:"signals"`
+	GracePeriod float64  `json:"grace_period"`
+}
+
+// Setup makes cmd the leader of its own process group so that the whole
+// tree it spawns can be signaled at once.
+func Setup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+}
+
+// Terminate sends SIGTERM to the process group of cmd, then SIGKILL if the
+// group is still alive after grace (DefaultGracePeriod when zero). done must
+// be the channel receiving the result of cmd.Wait.
+func Terminate(cmd *exec.Cmd, done <-chan error, grace time.Duration, l zerolog.Logger) *Termination {
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+
+	t := &Termination{
+		GracePeriod: grace.Seconds(),
+	}
+
+	pgid := cmd.Process.Pid
+
+	t.signal(pgid, syscall.SIGTERM, "SIGTERM", l)
+
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	exited := false
+
+	for {
+		select {
+		case <-done:
+			exited = true
+			done = nil
+
+			if !alive(pgid) {
+				return t
+			}
+
+		case <-ticker.C:
+			if exited && !alive(pgid) {
+				return t
+			}
+
+		case <-deadline.C:
+			l.Error().Msgf(
+				"Grace period of %s expired", grace,
+			)
+
+			t.signal(pgid, syscall.SIGKILL, "SIGKILL", l)
+
+			if !exited {
+				wait(done, l)
+			}
+
+			return t
+		}
+	}
+}
+
+// ----
+
+func (t *Termination) signal(pgid int, s syscall.Signal, name string, l zerolog.Logger) {
+	l.Error().Msgf(
+		"Sending %s to process group %d", name, pgid,
+	)
+
+	t.Signals = append(t.Signals, name)
+
+	err := syscall.Kill(-pgid, s)
+	if err != nil && err != syscall.ESRCH {
+		l.Error().Msgf(
+			"Failed to send %s to process group %d: %s",
+			name, pgid, err,
+		)
+	}
+}
+
+// alive tells whether the process group still has running members. Zombies
+// do not count, nobody may be there to reap orphans in a container.
+func alive(pgid int) bool {
+	if syscall.Kill(-pgid, 0) != nil {
+		return false
+	}
+
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil || len(stats) == 0 {
+		return true
+	}
+
+	for _, name := range stats {
+		buff, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+
+		// pid (comm) state ppid pgrp ...
+		i := bytes.LastIndexByte(buff, ')')
+		if i < 0 {
+			continue
+		}
+
+		fields := strings.Fields(string(buff[i+1:]))
+		if len(fields) < 3 || fields[0] == "Z" {
+			continue
+		}
+
+		if fields[2] == strconv.Itoa(pgid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func wait(done <-chan error, l zerolog.Logger) {
+	select {
+	case <-done:
+
+	case <-time.After(killWait):
+		l.Error().Msg(
+			"Command still running after SIGKILL",
+		)
+	}
+}
@@ -0,0 +1,163 @@
+package procgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	tmpDir string
+)
+
+func TestTerminate(t *testing.T) {
+	testFuncs := map[string]func(*testing.T){
+		"sigterm":    testSIGTERM,
+		"sigkill":    testSIGKILL,
+		"orphans":    testOrphans,
+		"early exit": testEarlyExit,
+	}
+
+	for desc, f := range testFuncs {
+		setUp(t)
+		t.Run(desc, f)
+		tearDown(t)
+	}
+}
+
+func setUp(t *testing.T) {
+	d, err := ioutil.TempDir(
+		"", "procgrouptestsuite",
+	)
+
+	require.Nil(t, err)
+
+	tmpDir = d
+}
+
+func tearDown(t *testing.T) {
+	err := os.RemoveAll(tmpDir)
+	require.Nil(t, err)
+}
+
+func testSIGTERM(t *testing.T) {
+	cmd, done := start(t, "sleep 30 & echo $! > pids; wait")
+
+	term := Terminate(cmd, done, time.Second, zerolog.Nop())
+
+	require.Equal(t, []string{"SIGTERM"}, term.Signals)
+	require.Equal(t, 1.0, term.GracePeriod)
+
+	checkDead(t)
+}
+
+func testSIGKILL(t *testing.T) {
+	cmd, done := start(t, "trap '' TERM; sleep 30 & echo $! > pids; wait")
+
+	term := Terminate(cmd, done, 200*time.Millisecond, zerolog.Nop())
+
+	require.Equal(t, []string{"SIGTERM", "SIGKILL"}, term.Signals)
+
+	checkDead(t)
+}
+
+// the direct child goes away on SIGTERM, a grandchild ignoring it must
+// still be killed once the grace period expires
+func testOrphans(t *testing.T) {
+	cmd, done := start(t,
+		"sh -c \"trap '' TERM; sleep 30\" >/dev/null 2>&1 & echo $! > pids; wait",
+	)
+
+	term := Terminate(cmd, done, 300*time.Millisecond, zerolog.Nop())
+
+	require.Equal(t, []string{"SIGTERM", "SIGKILL"}, term.Signals)
+
+	checkDead(t)
+}
+
+func testEarlyExit(t *testing.T) {
+	cmd, done := start(t, "echo $$ > pids; exit 0")
+
+	time.Sleep(100 * time.Millisecond)
+
+	term := Terminate(cmd, done, time.Second, zerolog.Nop())
+
+	require.Equal(t, []string{"SIGTERM"}, term.Signals)
+}
+
+// ----
+
+func start(t *testing.T, script string) (*exec.Cmd, chan error) {
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = tmpDir
+
+	Setup(cmd)
+
+	err := cmd.Start()
+	require.Nil(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	// wait for the script to record its children
+	for i := 0; i < 100; i++ {
+		_, err := os.Stat(filepath.Join(tmpDir, "pids"))
+		if err == nil {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	return cmd, done
+}
+
+func checkDead(t *testing.T) {
+	buff, err := ioutil.ReadFile(
+		filepath.Join(tmpDir, "pids"),
+	)
+	require.Nil(t, err)
+
+	for _, p := range strings.Fields(string(buff)) {
+		pid, err := strconv.Atoi(p)
+		require.Nil(t, err)
+
+		alive := false
+
+		for i := 0; i < 50; i++ {
+			alive = syscall.Kill(pid, 0) == nil && !isZombie(pid)
+			if !alive {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		require.False(t, alive, "process %d still running", pid)
+	}
+}
+
+func isZombie(pid int) bool {
+	buff, err := ioutil.ReadFile(
+		filepath.Join("/proc", strconv.Itoa(pid), "stat"),
+	)
+
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(buff), ") Z ")
+}
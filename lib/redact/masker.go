@@ -0,0 +1,198 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	Mask = "********"
+
+	// shorter values would mask too much of the logs to be of any use
+	minSecretLen = 4
+)
+
+// Masker replaces secret values and pattern matches with Mask.
+type Masker struct {
+	secrets  [][]byte
+	patterns []*regexp.Regexp
+
+	m sync.RWMutex
+}
+
+// NewMasker compiles patterns. When a pattern has capture groups only the
+// groups are masked, "password=(\S+)" keeps "password=" visible.
+func NewMasker(patterns []string) (*Masker, error) {
+	m := new(Masker)
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m, nil
+}
+
+// AddSecrets registers values to mask. Each line of a multi-line secret,
+// such as a private key, is masked on its own as well as the JSON escaped
+// form of the value.
+func (m *Masker) AddSecrets(secrets ...string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for _, s := range secrets {
+		variants := []string{s}
+
+		for _, l := range strings.Split(s, "\n") {
+			variants = append(variants, strings.TrimSpace(l))
+		}
+
+		for _, v := range variants {
+			m.add(v)
+			m.add(jsonEscape(v))
+		}
+	}
+
+	// longest first, so that a secret containing another one is masked as
+	// a whole
+	sort.SliceStable(m.secrets, func(i, j int) bool {
+		return len(m.secrets[i]) > len(m.secrets[j])
+	})
+}
+
+func (m *Masker) Mask(buff []byte) []byte {
+	if m == nil {
+		return buff
+	}
+
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	for _, s := range m.secrets {
+		buff = bytes.Replace(buff, s, []byte(Mask), -1)
+	}
+
+	for _, re := range m.patterns {
+		buff = maskPattern(re, buff)
+	}
+
+	return buff
+}
+
+func (m *Masker) MaskString(s string) string {
+	return string(m.Mask([]byte(s)))
+}
+
+// ----
+
+func (m *Masker) add(s string) {
+	if len(s) < minSecretLen {
+		return
+	}
+
+	for _, known := range m.secrets {
+		if string(known) == s {
+			return
+		}
+	}
+
+	m.secrets = append(m.secrets, []byte(s))
+}
+
+func (m *Masker) maxLen() int {
+	if m == nil {
+		return 0
+	}
+
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	if len(m.secrets) == 0 {
+		return 0
+	}
+
+	return len(m.secrets[0])
+}
+
+// safeCut moves n back so that buff[:n] does not end in the middle of a
+// secret.
+func (m *Masker) safeCut(buff []byte, n int) int {
+	if m == nil {
+		return n
+	}
+
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	for moved := true; moved; {
+		moved = false
+
+		for _, s := range m.secrets {
+			start := n - len(s) + 1
+			if start < 0 {
+				start = 0
+			}
+
+			end := n + len(s) - 1
+			if end > len(buff) {
+				end = len(buff)
+			}
+
+			if start >= end {
+				continue
+			}
+
+			i := bytes.Index(buff[start:end], s)
+			if i >= 0 && start+i < n && start+i+len(s) > n {
+				n = start + i
+				moved = true
+			}
+		}
+	}
+
+	return n
+}
+
+func maskPattern(re *regexp.Regexp, buff []byte) []byte {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAll(buff, []byte(Mask))
+	}
+
+	return re.ReplaceAllFunc(buff, func(match []byte) []byte {
+		idx := re.FindSubmatchIndex(match)
+
+		out := []byte{}
+		last := 0
+
+		for g := 1; g < len(idx)/2; g++ {
+			start, end := idx[2*g], idx[2*g+1]
+
+			if start < last || start < 0 {
+				continue
+			}
+
+			out = append(out, match[last:start]...)
+			out = append(out, Mask...)
+			last = end
+		}
+
+		return append(out, match[last:]...)
+	})
+}
+
+func jsonEscape(s string) string {
+	buff, err := json.Marshal(s)
+	if err != nil {
+		return s
+	}
+
+	return string(buff[1 : len(buff)-1])
+}
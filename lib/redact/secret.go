@@ -0,0 +1,9 @@
+package redact
+
+// Secret is a configuration value which encoding/json never writes out, the
+// configurations end up in the callback payloads.
+type Secret string
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`""`), nil
+}
@@ -0,0 +1,87 @@
+package redact
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// above this size, a line is released even though it is not complete
+const maxCarry = 4096
+
+// Writer masks what is written to it before passing it to the underlying
+// writer. Output is released line by line, a partial line being held until
+// it is completed, so that a secret split across two writes is still
+// masked. Flush must be called once done.
+type Writer struct {
+	w      io.Writer
+	masker *Masker
+
+	carry []byte
+	m     sync.Mutex
+}
+
+func NewWriter(w io.Writer, m *Masker) *Writer {
+	return &Writer{
+		w:      w,
+		masker: m,
+	}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.carry = append(w.carry, p...)
+
+	n := bytes.LastIndexAny(w.carry, "\r\n") + 1
+
+	if n == 0 && len(w.carry) > maxCarry {
+		// keep enough to complete a secret starting at the very end
+		n = len(w.carry) - w.masker.maxLen() + 1
+
+		if n > len(w.carry) {
+			n = len(w.carry)
+		}
+	}
+
+	if n <= 0 {
+		return len(p), nil
+	}
+
+	err := w.release(
+		w.masker.safeCut(w.carry, n),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush writes whatever is still held.
+func (w *Writer) Flush() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.release(len(w.carry))
+}
+
+// ----
+
+func (w *Writer) release(n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	out := w.masker.Mask(
+		append([]byte{}, w.carry[:n]...),
+	)
+
+	w.carry = append(w.carry[:0], w.carry[n:]...)
+
+	_, err := w.w.Write(out)
+
+	return err
+}
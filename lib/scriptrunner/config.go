@@ -1,7 +1,14 @@
 package scriptrunner
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/rs/zerolog"
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/redact"
 )
 
 type Config struct {
@@ -10,5 +17,35 @@ type Config struct {
 	WorkDir        string   `json:"-"`
 	ExtraEnv       []string `json:"-"`
 
+	// Directory of the checkout the script is run from, its root by default
+	Subdir string `json:"script_subdir"`
+
+	// Variables exported to the build script, secret ones are masked in
+	// the logs
+	Env       map[string]string `json:"env"`
+	SecretEnv map[string]string `json:"secret_env"`
+
+	// Reject scripts referencing variables which are not declared
+	StrictEnv bool `json:"strict_env"`
+
+	Timeout         duration.Duration `json:"script_timeout"`
+	KillGracePeriod time.Duration     `json:"-"`
+
 	Logger zerolog.Logger `json:"-"`
+	Masker *redact.Masker `json:"-"`
+}
+
+// CheckSubdir makes sure script_subdir stays within the checkout.
+func (c *Config) CheckSubdir() error {
+	if c.Subdir == "" {
+		return nil
+	}
+
+	p := filepath.Clean(c.Subdir)
+
+	if filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return fmt.Errorf("script_subdir: %q is not within the checkout", c.Subdir)
+	}
+
+	return nil
 }
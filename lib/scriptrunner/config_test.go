@@ -0,0 +1,34 @@
+package scriptrunner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSubdir(t *testing.T) {
+	testCases := []struct {
+		subdir string
+		valid  bool
+	}{
+		{"", true},
+		{"services/api", true},
+		{"./services/../api", true},
+		{"/etc", false},
+		{"..", false},
+		{"../other", false},
+		{"services/../../other", false},
+	}
+
+	for _, tc := range testCases {
+		c := &Config{Subdir: tc.subdir}
+
+		err := c.CheckSubdir()
+
+		if tc.valid {
+			require.Nil(t, err, tc.subdir)
+		} else {
+			require.NotNil(t, err, tc.subdir)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// variables set by the shell itself
+	shellVars = []string{
+		"BASH", "BASHPID", "BASH_REMATCH", "BASH_SOURCE", "BASH_VERSION",
+		"EUID", "FUNCNAME", "GROUPS", "HOSTNAME", "HOSTTYPE", "IFS",
+		"LINENO", "OLDPWD", "OPTARG", "OPTIND", "OSTYPE", "PIPESTATUS",
+		"PPID", "PWD", "RANDOM", "REPLY", "SECONDS", "SHLVL", "UID",
+	}
+
+	// $NAME or ${NAME, a default value (${NAME:-x}) makes the reference
+	// optional
+	envRefRegexp = regexp.MustCompile(
+		`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)(:?[-=?+])?|([A-Za-z_][A-Za-z0-9_]*))`,
+	)
+
+	envAssignRegexp = regexp.MustCompile(
+		`(?m)(?:^|[\s;&|(])([A-Za-z_][A-Za-z0-9_]*)(?:\[[^\]]*\])?\+?=`,
+	)
+
+	forRegexp  = regexp.MustCompile(`\bfor\s+([A-Za-z_][A-Za-z0-9_]*)\s`)
+	readRegexp = regexp.MustCompile(`\bread\s+((?:-\S+\s+)*)([A-Za-z_][A-Za-z0-9_ \t]*)`)
+
+	singleQuotedRegexp = regexp.MustCompile(`'[^']*'`)
+)
+
+// Environ returns the job environment variables, secret ones included.
+func (c *Config) Environ() []string {
+	env := []string{}
+
+	for _, vars := range []map[string]string{c.Env, c.SecretEnv} {
+		for _, k := range sortedKeys(vars) {
+			env = append(
+				env, fmt.Sprintf("%s=%s", k, vars[k]),
+			)
+		}
+	}
+
+	return env
+}
+
+// CheckEnvReferences returns an error listing the variables build_script
+// references without declaring them. Variables in declared, env and
+// secret_env, set by the shell or assigned in the script are declared.
+func (c *Config) CheckEnvReferences(declared []string) error {
+	known := map[string]bool{}
+
+	for _, list := range [][]string{declared, shellVars, sortedKeys(c.Env), sortedKeys(c.SecretEnv)} {
+		for _, k := range list {
+			known[k] = true
+		}
+	}
+
+	// nothing is expanded between single quotes
+	script := singleQuotedRegexp.ReplaceAllString(c.ScriptContents, "''")
+	script = strings.Replace(script, `\$`, "", -1)
+
+	for _, m := range envAssignRegexp.FindAllStringSubmatch(script, -1) {
+		known[m[1]] = true
+	}
+
+	for _, m := range forRegexp.FindAllStringSubmatch(script, -1) {
+		known[m[1]] = true
+	}
+
+	for _, m := range readRegexp.FindAllStringSubmatch(script, -1) {
+		for _, k := range strings.Fields(m[2]) {
+			known[k] = true
+		}
+	}
+
+	undeclared := map[string]bool{}
+
+	for _, m := range envRefRegexp.FindAllStringSubmatch(script, -1) {
+		name := m[1] + m[3]
+
+		if m[2] != "" || known[name] {
+			continue
+		}
+
+		undeclared[name] = true
+	}
+
+	if len(undeclared) == 0 {
+		return nil
+	}
+
+	names := []string{}
+	for k := range undeclared {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	return fmt.Errorf(
+		"build_script references undeclared environment variables: %s",
+		strings.Join(names, ", "),
+	)
+}
+
+// ----
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,76 @@
+package scriptrunner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnviron(t *testing.T) {
+	c := &Config{
+		Env: map[string]string{
+			"B": "2",
+			"A": "1",
+		},
+		SecretEnv: map[string]string{
+			"TOKEN": "s3cr3t",
+		},
+	}
+
+	require.Equal(t,
+		[]string{"A=1", "B=2", "TOKEN=s3cr3t"},
+		c.Environ(),
+	)
+}
+
+func TestCheckEnvReferences(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		script string
+		err    string
+	}{
+		{
+			desc:   "declared variables",
+			script: "echo $HOME $IMAGE ${TOKEN} $PWD",
+		},
+		{
+			desc:   "undeclared variables",
+			script: "echo $IMAGE $TAG ${REGISTRY}/x $TAG",
+			err:    "build_script references undeclared environment variables: REGISTRY, TAG",
+		},
+		{
+			desc:   "default values",
+			script: "echo ${TAG:-latest} ${REGISTRY-docker.io} ${X:?}",
+		},
+		{
+			desc:   "assigned in the script",
+			script: "TAG=v1\nexport REGISTRY=r\nfor f in a b; do echo $f; done\nread -r L1 L2\necho $TAG $REGISTRY $L1 $L2",
+		},
+		{
+			desc:   "not expanded",
+			script: "echo '$TAG' \\$REGISTRY $1 $?",
+		},
+	}
+
+	for _, tc := range testCases {
+		c := &Config{
+			ScriptContents: tc.script,
+			Env: map[string]string{
+				"IMAGE": "x",
+			},
+			SecretEnv: map[string]string{
+				"TOKEN": "y",
+			},
+		}
+
+		err := c.CheckEnvReferences([]string{"HOME"})
+
+		if tc.err == "" {
+			require.Nil(t, err, tc.desc)
+			continue
+		}
+
+		require.NotNil(t, err, tc.desc)
+		require.Equal(t, tc.err, err.Error(), tc.desc)
+	}
+}
@@ -2,13 +2,20 @@ package scriptrunner
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+	"github.com/squarescale/simple-builder/lib/procgroup"
+	"github.com/squarescale/simple-builder/lib/redact"
 )
 
 type Runner struct {
 	ProcessState *os.ProcessState
+	Termination  *procgroup.Termination
 
 	Cfg *Config
 
@@ -28,7 +35,10 @@ func New(ctx context.Context, c *Config) *Runner {
 }
 
 func (r *Runner) Run() error {
-	err := r.ctx.Err()
+	ctx, cancelFunc := duration.Context(r.ctx, r.Cfg.Timeout)
+	defer cancelFunc()
+
+	err := ctx.Err()
 	if err != nil {
 		return err
 	}
@@ -44,16 +54,25 @@ func (r *Runner) Run() error {
 
 	cmd.Dir = r.Cfg.WorkDir
 
-	cmd.Stdout = r.Cfg.Logger
-	cmd.Stderr = r.Cfg.Logger
+	procgroup.Setup(cmd)
+
+	stdout := redact.NewWriter(r.Cfg.Logger, r.Cfg.Masker)
+	stderr := redact.NewWriter(r.Cfg.Logger, r.Cfg.Masker)
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	cmd.Env = append(
 		cmd.Env, r.Cfg.ExtraEnv...,
 	)
 
+	cmd.Env = append(
+		cmd.Env, r.Cfg.Environ()...,
+	)
+
 	r.dumpCmd(cmd)
 
-	err = r.ctx.Err()
+	err = ctx.Err()
 	if err != nil {
 		return err
 	}
@@ -63,21 +82,34 @@ func (r *Runner) Run() error {
 		return err
 	}
 
+	// the state is sent first, it is there once errChan got the result
+	stateChan := make(chan *os.ProcessState, 1)
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- cmd.Wait()
-		r.ProcessState = cmd.ProcessState
+		err := cmd.Wait()
+		stdout.Flush()
+		stderr.Flush()
+		stateChan <- cmd.ProcessState
+		errChan <- err
 	}()
 
 	select {
-	case <-r.ctx.Done():
-		cmd.Process.Kill()
+	case <-ctx.Done():
+		r.Cfg.Logger.Error().Msgf(
+			"\nContext expired (%s), terminating command\n\n", ctx.Err(),
+		)
 
-		r.Cfg.Logger.Error().Msg(
-			"\nContext expired, command killed\n\n",
+		r.Termination = procgroup.Terminate(
+			cmd, errChan, r.Cfg.KillGracePeriod, r.Cfg.Logger,
 		)
 
-		return r.ctx.Err()
+		// still running when Terminate gave up waiting
+		select {
+		case r.ProcessState = <-stateChan:
+		default:
+		}
+
+		return ctx.Err()
 
 	case err := <-errChan:
 		if err != nil {
@@ -86,6 +118,8 @@ func (r *Runner) Run() error {
 			)
 		}
 
+		r.ProcessState = <-stateChan
+
 		return err
 	}
 }
@@ -105,5 +139,22 @@ func (r *Runner) dumpCmd(cmd *exec.Cmd) {
 
 	l.Info().Msgf("WD: %s", r.Cfg.WorkDir)
 	l.Info().Msgf("ARGS: %q", cmd.Args)
-	l.Info().Msgf("ENV: %q", cmd.Env)
+	l.Info().Msgf("ENV: %q", r.displayEnv(cmd.Env))
+}
+
+// displayEnv hides the value of secret variables.
+func (r *Runner) displayEnv(env []string) []string {
+	buff := []string{}
+
+	for _, e := range env {
+		k := strings.SplitN(e, "=", 2)[0]
+
+		if _, found := r.Cfg.SecretEnv[k]; found {
+			e = fmt.Sprintf("%s=%s", k, redact.Mask)
+		}
+
+		buff = append(buff, e)
+	}
+
+	return buff
 }
@@ -7,8 +7,10 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/squarescale/simple-builder/lib/duration"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,6 +22,8 @@ func TestScriptRunner(t *testing.T) {
 	testFuncs := map[string]func(*testing.T){
 		"write build file": testWriteBuildFile,
 		"run success":      testRunSuccess,
+		"run timeout":      testRunTimeout,
+		"run detached":     testRunDetached,
 	}
 
 	for desc, f := range testFuncs {
@@ -104,6 +108,51 @@ func testRunSuccess(t *testing.T) {
 	require.True(t, info.Size() >= 700)
 }
 
+func testRunTimeout(t *testing.T) {
+	c := New(context.Background(), &Config{
+		ScriptContents: "#!/bin/sh\nsleep 10\n",
+		ScriptFile:     filepath.Join(tmpDir, "build"),
+
+		WorkDir:  tmpDir,
+		Logger:   zerolog.Nop(),
+		ExtraEnv: extraEnv(),
+		Timeout: duration.Duration{
+			Duration: 100 * time.Millisecond,
+		},
+	})
+
+	start := time.Now()
+
+	err := c.Run()
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.True(t, time.Since(start) < 5*time.Second)
+}
+
+func testRunDetached(t *testing.T) {
+	// the detached sleep keeps the output open past the kill, Terminate
+	// gives up waiting for the command
+	c := New(context.Background(), &Config{
+		ScriptContents: "#!/bin/sh\nsetsid sleep 6 &\nsleep 10\n",
+		ScriptFile:     filepath.Join(tmpDir, "build"),
+
+		WorkDir:         tmpDir,
+		Logger:          zerolog.Nop(),
+		ExtraEnv:        extraEnv(),
+		KillGracePeriod: 100 * time.Millisecond,
+		Timeout: duration.Duration{
+			Duration: 100 * time.Millisecond,
+		},
+	})
+
+	err := c.Run()
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Nil(t, c.ProcessState)
+
+	// the command is waited for once the sleep exits, Run returned already
+	time.Sleep(1500 * time.Millisecond)
+	require.Nil(t, c.ProcessState)
+}
+
 func ensureDoesNotExist(t *testing.T, path string) {
 	_, err := os.Stat(path)
 	require.NotNil(t, err)
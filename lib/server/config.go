@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	defaultAddr         = "127.0.0.1:8080"
+	defaultConcurrency  = 1
+	defaultQueueSize    = 16
+	defaultKeepFinished = 100
+	defaultMaxJobBytes  = 10 << 20
+)
+
+type Config struct {
+	Addr string
+
+	// Number of builds run at once, and of builds waiting for their turn,
+	// submissions are rejected once the queue is full
+	Concurrency int
+	QueueSize   int
+
+	// Number of finished builds which status and logs are kept
+	KeepFinished int
+
+	MaxJobBytes int64
+
+	// When set, requests must carry it as a bearer token, it is required
+	// unless Addr is a loopback address
+	Token string
+}
+
+func (c *Config) setDefaults() {
+	if c.Addr == "" {
+		c.Addr = defaultAddr
+	}
+
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultConcurrency
+	}
+
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultQueueSize
+	}
+
+	if c.KeepFinished <= 0 {
+		c.KeepFinished = defaultKeepFinished
+	}
+
+	if c.MaxJobBytes <= 0 {
+		c.MaxJobBytes = defaultMaxJobBytes
+	}
+}
+
+// check refuses to serve without a token beyond the loopback interface,
+// anyone reaching the server could run commands on it.
+func (c *Config) check() error {
+	if c.Token != "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+
+	if host == "localhost" || ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"a token is required to listen on %s, which is not a loopback address",
+		c.Addr,
+	)
+}
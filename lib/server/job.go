@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/builder"
+)
+
+type State string
+
+const (
+	StateQueued   State = "queued"
+	StateRunning  State = "running"
+	StateFinished State = "finished"
+)
+
+// Job is a build submitted to the server. Its fields are guarded by the
+// server mutex, the builder is created once a worker picks the job, so
+// that the time spent in the queue does not count against build_timeout.
+type Job struct {
+	ID    string `json:"id"`
+	State State  `json:"state"`
+
+	Status   builder.Status `json:"status,omitempty"`
+	ExitCode *int           `json:"exit_code,omitempty"`
+	Error    string         `json:"error,omitempty"`
+
+	// exit code of the build script, -1 when killed by a signal
+	ScriptExitCode *int `json:"script_exit_code,omitempty"`
+
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+
+	cfg        *builder.Config
+	builder    *builder.Builder
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	output     string
+}
+
+// readLog returns the build log so far, the log file is removed along with
+// the workdir once the build is finished.
+func readLog(b *builder.Builder) (string, error) {
+	buff, err := ioutil.ReadFile(
+		b.LogPath(),
+	)
+
+	return string(buff), err
+}
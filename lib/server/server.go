@@ -0,0 +1,431 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/builder"
+)
+
+// Server runs the build jobs submitted over HTTP, each one with its own
+// builder.Builder:
+//
+//	POST /jobs               submits a job, same format as -build-job
+//	GET  /jobs               lists the jobs
+//	GET  /jobs/{id}          status of a job
+//	GET  /jobs/{id}/logs     build log, so far if still running
+//	POST /jobs/{id}/cancel   cancels a job
+type Server struct {
+	Cfg *Config
+
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	finished []string
+	closed   bool
+
+	queue chan *Job
+	wg    *sync.WaitGroup
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func New(ctx context.Context, cfg *Config) *Server {
+	ctx2, cancelFunc := context.WithCancel(ctx)
+
+	cfg.setDefaults()
+
+	return &Server{
+		Cfg: cfg,
+
+		jobs:  map[string]*Job{},
+		queue: make(chan *Job, cfg.QueueSize),
+		wg:    &sync.WaitGroup{},
+
+		ctx:        ctx2,
+		cancelFunc: cancelFunc,
+	}
+}
+
+// Run serves the API until the context is done, the running builds are
+// then cancelled and waited for. Without a token, only a loopback address
+// is served.
+func (s *Server) Run() error {
+	err := s.Cfg.check()
+	if err != nil {
+		s.Close()
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:    s.Cfg.Addr,
+		Handler: s,
+	}
+
+	s.startWorkers()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- srv.ListenAndServe()
+	}()
+
+	log.Printf("Serving build jobs on %s", s.Cfg.Addr)
+
+	select {
+	case <-s.ctx.Done():
+		ctx, cancelFunc := context.WithTimeout(
+			context.Background(), 5*time.Second,
+		)
+		defer cancelFunc()
+
+		err = srv.Shutdown(ctx)
+
+	case err = <-errChan:
+	}
+
+	s.Close()
+
+	return err
+}
+
+// Close cancels every job and waits for the workers to be done with them.
+func (s *Server) Close() {
+	s.mu.Lock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+
+	s.mu.Unlock()
+
+	s.cancelFunc()
+	s.wg.Wait()
+
+	// left over when no worker was ever started
+	for j := range s.queue {
+		s.discard(j)
+	}
+}
+
+func (s *Server) startWorkers() {
+	for i := 0; i < s.Cfg.Concurrency; i++ {
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+
+			for j := range s.queue {
+				s.runJob(j)
+			}
+		}()
+	}
+}
+
+func (s *Server) runJob(j *Job) {
+	b, err := builder.NewFromConfig(j.ctx, j.cfg)
+	if err != nil {
+		log.Printf("Job %s: %s", j.ID, err)
+		s.fail(j, err)
+		return
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	j.State = StateRunning
+	j.StartedAt = &now
+	j.builder = b
+	s.mu.Unlock()
+
+	err = b.Run()
+	if err != nil {
+		log.Printf("Job %s: %s", j.ID, err)
+	}
+
+	code := b.ExitCode()
+	now = time.Now()
+
+	s.mu.Lock()
+	j.State = StateFinished
+	j.Status = b.Status
+	j.ExitCode = &code
+	j.FinishedAt = &now
+	j.output = b.Output
+
+	if b.Script != nil {
+		scriptCode := b.Script.ExitCode
+		j.ScriptExitCode = &scriptCode
+	}
+	s.finish(j)
+	s.mu.Unlock()
+
+	// the log file is not needed anymore, output holds it
+	b.Cleanup()
+	j.cancelFunc()
+}
+
+// fail finishes a job which build could not be prepared.
+func (s *Server) fail(j *Job, err error) {
+	code := builder.NewExitCode(err)
+	now := time.Now()
+
+	s.mu.Lock()
+	j.State = StateFinished
+	j.Status = builder.StatusInternalError
+	j.ExitCode = &code
+	j.Error = err.Error()
+	j.FinishedAt = &now
+	s.finish(j)
+	s.mu.Unlock()
+
+	j.cancelFunc()
+}
+
+// finish forgets the oldest finished jobs beyond KeepFinished, s.mu must be
+// held.
+func (s *Server) finish(j *Job) {
+	s.finished = append(s.finished, j.ID)
+
+	for len(s.finished) > s.Cfg.KeepFinished {
+		delete(s.jobs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+// ---
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		httpError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	parts := strings.Split(
+		strings.Trim(r.URL.Path, "/"), "/",
+	)
+
+	if parts[0] != "jobs" || len(parts) > 3 {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.submit(w, r)
+
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.list(w)
+
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.withJob(w, parts[1], s.status)
+
+	case len(parts) == 3 && parts[2] == "logs" && r.Method == http.MethodGet:
+		s.withJob(w, parts[1], s.logs)
+
+	case len(parts) == 3 && parts[2] == "cancel" && r.Method == http.MethodPost:
+		s.withJob(w, parts[1], s.cancel)
+
+	default:
+		httpError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Cfg.Token == "" {
+		return true
+	}
+
+	token := strings.TrimPrefix(
+		r.Header.Get("Authorization"), "Bearer ",
+	)
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Cfg.Token)) == 1
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	buff, err := ioutil.ReadAll(
+		http.MaxBytesReader(w, r.Body, s.Cfg.MaxJobBytes),
+	)
+
+	if err != nil {
+		httpError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	j, err := s.newJob(buff)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		s.discard(j)
+		httpError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+
+	select {
+	case s.queue <- j:
+		s.jobs[j.ID] = j
+
+	default:
+		s.discard(j)
+		httpError(w, http.StatusServiceUnavailable, "queue full")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// newJob checks the configuration of a job right away, its builder is
+// created once a worker picks it.
+func (s *Server) newJob(buff []byte) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := builder.NewConfig(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	err = builder.CheckConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancelFunc := context.WithCancel(s.ctx)
+
+	return &Job{
+		ID:          id,
+		State:       StateQueued,
+		SubmittedAt: time.Now(),
+
+		cfg:        cfg,
+		ctx:        ctx,
+		cancelFunc: cancelFunc,
+	}, nil
+}
+
+// discard drops a job which could not be queued.
+func (s *Server) discard(j *Job) {
+	j.cancelFunc()
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []*Job{}
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) withJob(w http.ResponseWriter, id string, f func(http.ResponseWriter, *Job)) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+
+	if !ok {
+		httpError(w, http.StatusNotFound, fmt.Sprintf("job %s not found", id))
+		return
+	}
+
+	f(w, j)
+}
+
+func (s *Server) status(w http.ResponseWriter, j *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (s *Server) logs(w http.ResponseWriter, j *Job) {
+	s.mu.Lock()
+	finished, output, b := j.State == StateFinished, j.output, j.builder
+	s.mu.Unlock()
+
+	// nothing logged yet while queued
+	if !finished && b != nil {
+		var err error
+
+		output, err = readLog(b)
+
+		// the build may have finished in the meantime
+		if err != nil {
+			s.mu.Lock()
+			finished, output = j.State == StateFinished, j.output
+			s.mu.Unlock()
+		}
+
+		if err != nil && !finished {
+			httpError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(output))
+}
+
+// cancel stops a running build, a queued one is cancelled as soon as it
+// starts, so that its callbacks are still sent.
+func (s *Server) cancel(w http.ResponseWriter, j *Job) {
+	j.cancelFunc()
+
+	s.status(w, j)
+}
+
+// ---
+
+func newID() (string, error) {
+	buff := make([]byte, 16)
+
+	_, err := rand.Read(buff)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buff), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	buff, err := json.Marshal(v)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buff)
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	buff, _ := json.Marshal(map[string]string{
+		"error": msg,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buff)
+}
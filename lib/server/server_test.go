@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/squarescale/simple-builder/lib/builder"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	tmpDir string
+)
+
+func TestServer(t *testing.T) {
+	testFuncs := map[string]func(t *testing.T){
+		"build":       testBuild,
+		"cancel":      testCancel,
+		"queue full":  testQueueFull,
+		"invalid job": testInvalidJob,
+		"token":       testToken,
+		"not found":   testNotFound,
+		"queue time":  testQueueTime,
+		"listen":      testListen,
+	}
+
+	for desc, f := range testFuncs {
+		setUp(t)
+		t.Run(desc, f)
+		tearDown(t)
+	}
+}
+
+func testBuild(t *testing.T) {
+	s, srv := newTestServer(t, &Config{})
+	defer s.Close()
+	defer srv.Close()
+
+	j := submit(t, srv.URL, "#!/bin/sh\necho built\n", http.StatusAccepted)
+	require.Equal(t, StateQueued, j.State)
+
+	j = waitFinished(t, srv.URL, j.ID)
+	require.Equal(t, builder.StatusSuccess, j.Status)
+	require.Equal(t, 0, *j.ExitCode)
+
+	resp, err := http.Get(srv.URL + "/jobs/" + j.ID + "/logs")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Contains(t, string(buff), "built")
+
+	// ---
+
+	jobs := []*Job{}
+	getJSON(t, srv.URL+"/jobs", &jobs)
+	require.Len(t, jobs, 1)
+
+	// ---
+
+	j = submit(t, srv.URL, "#!/bin/sh\nexit 70\n", http.StatusAccepted)
+
+	j = waitFinished(t, srv.URL, j.ID)
+	require.Equal(t, builder.StatusScriptFailed, j.Status)
+	require.Equal(t, builder.ExitScriptFailed, *j.ExitCode)
+	require.Equal(t, 70, *j.ScriptExitCode)
+}
+
+func testCancel(t *testing.T) {
+	s, srv := newTestServer(t, &Config{})
+	defer s.Close()
+	defer srv.Close()
+
+	j := submit(t, srv.URL, "#!/bin/sh\necho started\nsleep 10\n", http.StatusAccepted)
+
+	// the script must be running, not only the job
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(srv.URL + "/jobs/" + j.ID + "/logs")
+		require.Nil(t, err)
+
+		buff, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if bytes.Contains(buff, []byte("started")) {
+			break
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	resp, err := http.Post(srv.URL+"/jobs/"+j.ID+"/cancel", "", nil)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	j = waitFinished(t, srv.URL, j.ID)
+	require.Equal(t, builder.StatusCancelled, j.Status)
+	require.Equal(t, builder.ExitCancelled, *j.ExitCode)
+}
+
+func testQueueFull(t *testing.T) {
+	// no workers, jobs stay in the queue
+	s := New(context.Background(), &Config{
+		QueueSize: 1,
+	})
+	defer s.Close()
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	submit(t, srv.URL, "#!/bin/sh\nexit 0\n", http.StatusAccepted)
+	submit(t, srv.URL, "#!/bin/sh\nexit 0\n", http.StatusServiceUnavailable)
+}
+
+func testInvalidJob(t *testing.T) {
+	s, srv := newTestServer(t, &Config{})
+	defer s.Close()
+	defer srv.Close()
+
+	for _, job := range []string{`{`, `{"source": {"type": "svn"}}`} {
+		resp, err := http.Post(srv.URL+"/jobs", "application/json", bytes.NewBufferString(job))
+		require.Nil(t, err)
+		resp.Body.Close()
+
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, job)
+	}
+}
+
+func testToken(t *testing.T) {
+	s, srv := newTestServer(t, &Config{Token: "s3cr3t"})
+	defer s.Close()
+	defer srv.Close()
+
+	for token, code := range map[string]int{
+		"":       http.StatusUnauthorized,
+		"wrong":  http.StatusUnauthorized,
+		"s3cr3t": http.StatusOK,
+	} {
+		req, err := http.NewRequest("GET", srv.URL+"/jobs", nil)
+		require.Nil(t, err)
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+
+		require.Equal(t, code, resp.StatusCode, token)
+	}
+}
+
+func testNotFound(t *testing.T) {
+	s, srv := newTestServer(t, &Config{})
+	defer s.Close()
+	defer srv.Close()
+
+	for _, p := range []string{"/", "/builds", "/jobs/unknown", "/jobs/unknown/logs"} {
+		resp, err := http.Get(srv.URL + p)
+		require.Nil(t, err)
+		resp.Body.Close()
+
+		require.Equal(t, http.StatusNotFound, resp.StatusCode, p)
+	}
+}
+
+func testQueueTime(t *testing.T) {
+	s, srv := newTestServer(t, &Config{})
+	defer s.Close()
+	defer srv.Close()
+
+	first := submit(t, srv.URL, "#!/bin/sh\nsleep 1\n", http.StatusAccepted)
+
+	// waits for the first one longer than its build_timeout
+	j := submitJob(t, srv.URL, map[string]interface{}{
+		"build_script":  "#!/bin/sh\nexit 0\n",
+		"build_timeout": 0.5,
+	}, http.StatusAccepted)
+
+	waitFinished(t, srv.URL, first.ID)
+
+	j = waitFinished(t, srv.URL, j.ID)
+	require.Equal(t, builder.StatusSuccess, j.Status)
+}
+
+func testListen(t *testing.T) {
+	testCases := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{addr: "127.0.0.1:8080", ok: true},
+		{addr: "[::1]:8080", ok: true},
+		{addr: "localhost:8080", ok: true},
+		{addr: ":8080"},
+		{addr: "0.0.0.0:8080"},
+		{addr: "10.0.0.1:8080"},
+		{addr: ":8080", token: "s3cr3t", ok: true},
+	}
+
+	for _, tc := range testCases {
+		cfg := &Config{Addr: tc.addr, Token: tc.token}
+
+		err := cfg.check()
+		require.Equal(t, tc.ok, err == nil, tc.addr)
+	}
+
+	// ---
+
+	s := New(context.Background(), &Config{
+		Addr: "0.0.0.0:0",
+	})
+
+	err := s.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "token is required")
+}
+
+func setUp(t *testing.T) {
+	d, err := ioutil.TempDir(
+		"", "servertestsuite",
+	)
+
+	require.Nil(t, err)
+
+	tmpDir = d
+}
+
+func tearDown(t *testing.T) {
+	err := os.RemoveAll(tmpDir)
+	require.Nil(t, err)
+}
+
+func newTestServer(t *testing.T, cfg *Config) (*Server, *httptest.Server) {
+	s := New(context.Background(), cfg)
+	s.startWorkers()
+
+	return s, httptest.NewServer(s)
+}
+
+// submit posts a job building a local directory of tmpDir.
+func submit(t *testing.T, url, script string, code int) *Job {
+	return submitJob(t, url, map[string]interface{}{
+		"build_script": script,
+	}, code)
+}
+
+// submitJob posts a job building a local directory of tmpDir, job values
+// override the defaults.
+func submitJob(t *testing.T, url string, job map[string]interface{}, code int) *Job {
+	src := filepath.Join(tmpDir, "src")
+	require.Nil(t, os.MkdirAll(src, 0700))
+
+	cfg := map[string]interface{}{
+		"source": map[string]interface{}{
+			"type": "local",
+			"path": src,
+		},
+	}
+
+	for k, v := range job {
+		cfg[k] = v
+	}
+
+	buff, err := json.Marshal(cfg)
+	require.Nil(t, err)
+
+	resp, err := http.Post(url+"/jobs", "application/json", bytes.NewBuffer(buff))
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, code, resp.StatusCode)
+
+	j := new(Job)
+
+	err = json.NewDecoder(resp.Body).Decode(j)
+	require.Nil(t, err)
+
+	return j
+}
+
+func waitFinished(t *testing.T, url, id string) *Job {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		j := new(Job)
+		getJSON(t, url+"/jobs/"+id, j)
+
+		if j.State == StateFinished {
+			return j
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s not finished", id)
+
+	return nil
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	resp, err := http.Get(url)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	require.Nil(t, err)
+}
@@ -0,0 +1,171 @@
+// Package signature signs and verifies the callback payloads sent by
+// simple-builder.
+//
+// The signature is an HMAC-SHA256 of "<timestamp>.<nonce>.<body>" computed
+// with the job callback_secret. It is sent hex encoded in the
+// X-Simple-Builder-Signature header with a "sha256=" prefix, the unix
+// timestamp and nonce going in their own headers. Receivers should reject
+// stale timestamps and nonces they have already seen, which Verifier does.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderSignature = "X-Simple-Builder-Signature"
+	HeaderTimestamp = "X-Simple-Builder-Timestamp"
+	HeaderNonce     = "X-Simple-Builder-Nonce"
+
+	signaturePrefix = "sha256="
+
+	DefaultTolerance = 5 * time.Minute
+)
+
+var (
+	ErrMissingHeader    = errors.New("missing signature header")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidTimestamp = errors.New("invalid or expired timestamp")
+	ErrReplayedNonce    = errors.New("nonce already used")
+)
+
+// Sign returns the value of the signature header for the given payload.
+func Sign(secret []byte, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+
+	fmt.Fprintf(mac, "%d.%s.", timestamp, nonce)
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the signature, timestamp and nonce headers of req.
+func SignRequest(req *http.Request, secret []byte, body []byte) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+
+	ts := time.Now().Unix()
+
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Sign(secret, ts, nonce, body))
+
+	return nil
+}
+
+// Verifier checks signed payloads. It remembers the nonces seen within the
+// tolerance window so that a captured request cannot be replayed.
+type Verifier struct {
+	Secret    []byte
+	Tolerance time.Duration
+
+	now    func() time.Time
+	nonces map[string]time.Time
+	m      sync.Mutex
+}
+
+func NewVerifier(secret []byte, tolerance time.Duration) *Verifier {
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+
+	return &Verifier{
+		Secret:    secret,
+		Tolerance: tolerance,
+
+		now:    time.Now,
+		nonces: map[string]time.Time{},
+	}
+}
+
+// Verify checks the signature headers h against body.
+func (v *Verifier) Verify(h http.Header, body []byte) error {
+	sig := h.Get(HeaderSignature)
+	ts := h.Get(HeaderTimestamp)
+	nonce := h.Get(HeaderNonce)
+
+	if sig == "" || ts == "" || nonce == "" {
+		return ErrMissingHeader
+	}
+
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+
+	expected := Sign(v.Secret, timestamp, nonce, body)
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig))) {
+		return ErrInvalidSignature
+	}
+
+	now := v.now()
+	age := now.Sub(time.Unix(timestamp, 0))
+
+	if age > v.Tolerance || age < -v.Tolerance {
+		return ErrInvalidTimestamp
+	}
+
+	return v.useNonce(nonce, now)
+}
+
+// VerifyRequest reads and verifies the body of r. The body is returned and
+// also put back in r so that it can be read again by the caller.
+func (v *Verifier) VerifyRequest(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, v.Verify(r.Header, body)
+}
+
+// ----
+
+func (v *Verifier) useNonce(nonce string, now time.Time) error {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	for n, seen := range v.nonces {
+		if now.Sub(seen) > 2*v.Tolerance {
+			delete(v.nonces, n)
+		}
+	}
+
+	if _, found := v.nonces[nonce]; found {
+		return ErrReplayedNonce
+	}
+
+	v.nonces[nonce] = now
+
+	return nil
+}
+
+func newNonce() (string, error) {
+	buff := make([]byte, 16)
+
+	_, err := rand.Read(buff)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buff), nil
+}
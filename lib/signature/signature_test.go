@@ -0,0 +1,111 @@
+package signature
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	secret = []byte("s3cr3t")
+	body   = []byte(`{"errors":null}`)
+)
+
+func TestSignature(t *testing.T) {
+	testFuncs := map[string]func(*testing.T){
+		"sign":              testSign,
+		"verify request":    testVerifyRequest,
+		"invalid signature": testInvalidSignature,
+		"expired timestamp": testExpiredTimestamp,
+		"replayed nonce":    testReplayedNonce,
+		"missing headers":   testMissingHeaders,
+	}
+
+	for desc, f := range testFuncs {
+		t.Run(desc, f)
+	}
+}
+
+func testSign(t *testing.T) {
+	s1 := Sign(secret, 42, "n", body)
+	s2 := Sign(secret, 42, "n", body)
+	require.Equal(t, s1, s2)
+	require.Len(t, s1, len(signaturePrefix)+64)
+
+	require.NotEqual(t, s1, Sign(secret, 43, "n", body))
+	require.NotEqual(t, s1, Sign(secret, 42, "m", body))
+	require.NotEqual(t, s1, Sign([]byte("other"), 42, "n", body))
+}
+
+func testVerifyRequest(t *testing.T) {
+	r := newSignedRequest(t, secret)
+
+	v := NewVerifier(secret, 0)
+
+	buff, err := v.VerifyRequest(r)
+	require.Nil(t, err)
+	require.Equal(t, body, buff)
+
+	buff2 := new(bytes.Buffer)
+	buff2.ReadFrom(r.Body)
+	require.Equal(t, body, buff2.Bytes())
+}
+
+func testInvalidSignature(t *testing.T) {
+	r := newSignedRequest(t, []byte("wrong"))
+
+	_, err := NewVerifier(secret, 0).VerifyRequest(r)
+	require.Equal(t, ErrInvalidSignature, err)
+
+	// ---
+
+	r = newSignedRequest(t, secret)
+
+	err = NewVerifier(secret, 0).Verify(r.Header, []byte("tampered"))
+	require.Equal(t, ErrInvalidSignature, err)
+}
+
+func testExpiredTimestamp(t *testing.T) {
+	ts := time.Now().Add(-time.Hour).Unix()
+
+	h := http.Header{}
+	h.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	h.Set(HeaderNonce, "n")
+	h.Set(HeaderSignature, Sign(secret, ts, "n", body))
+
+	err := NewVerifier(secret, time.Minute).Verify(h, body)
+	require.Equal(t, ErrInvalidTimestamp, err)
+}
+
+func testReplayedNonce(t *testing.T) {
+	r := newSignedRequest(t, secret)
+
+	v := NewVerifier(secret, 0)
+
+	err := v.Verify(r.Header, body)
+	require.Nil(t, err)
+
+	err = v.Verify(r.Header, body)
+	require.Equal(t, ErrReplayedNonce, err)
+}
+
+func testMissingHeaders(t *testing.T) {
+	err := NewVerifier(secret, 0).Verify(http.Header{}, body)
+	require.Equal(t, ErrMissingHeader, err)
+}
+
+func newSignedRequest(t *testing.T, key []byte) *http.Request {
+	r := httptest.NewRequest(
+		http.MethodPost, "/cb", bytes.NewReader(body),
+	)
+
+	err := SignRequest(r, key, body)
+	require.Nil(t, err)
+
+	return r
+}
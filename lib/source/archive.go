@@ -0,0 +1,433 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+)
+
+// ChecksumError is returned when the downloaded archive does not match
+// the expected SHA-256 checksum.
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (err *ChecksumError) Error() string {
+	return fmt.Sprintf(
+		"source.sha256: archive checksum is %s instead of %s",
+		err.Actual, err.Expected,
+	)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+type link struct {
+	path   string
+	target string
+}
+
+// Archive downloads a tar, tar.gz or zip file and extracts it in Dir.
+type Archive struct {
+	Cfg *Config
+
+	// symbolic links, created once every file is extracted
+	links []*link
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func NewArchive(ctx context.Context, cfg *Config) *Archive {
+	ctx2, cancelFunc := context.WithCancel(ctx)
+
+	return &Archive{
+		Cfg: cfg,
+
+		ctx:        ctx2,
+		cancelFunc: cancelFunc,
+	}
+}
+
+func (a *Archive) Dir() string {
+	return a.Cfg.Dir
+}
+
+func (a *Archive) Cleanup() {
+	a.cancelFunc()
+}
+
+func (a *Archive) Run() error {
+	ctx, cancelFunc := duration.Context(a.ctx, a.Cfg.Timeout)
+	defer cancelFunc()
+
+	f, err := ioutil.TempFile(a.Cfg.WorkDir, "source-archive")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := a.download(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(a.Cfg.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = a.extract(ctx, f, size)
+	if err != nil {
+		return err
+	}
+
+	err = a.createLinks()
+	if err != nil {
+		return err
+	}
+
+	a.Cfg.Logger.Info().Msgf(
+		"SOURCE: %s extracted in %s", a.Cfg.SHA256, a.Cfg.Dir,
+	)
+
+	return nil
+}
+
+// download writes the archive to f and checks its checksum.
+func (a *Archive) download(ctx context.Context, f *os.File) (int64, error) {
+	a.Cfg.Logger.Info().Msgf("Downloading %s", redactURL(a.Cfg.URL))
+
+	req, err := http.NewRequest("GET", a.Cfg.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(
+		req.WithContext(ctx),
+	)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf(
+			"source.url: %s returned %s", redactURL(a.Cfg.URL), resp.Status,
+		)
+	}
+
+	h := sha256.New()
+
+	size, err := io.Copy(
+		io.MultiWriter(f, h), resp.Body,
+	)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		return 0, err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	expected := strings.ToLower(a.Cfg.SHA256)
+
+	if sum != expected {
+		return 0, &ChecksumError{
+			Expected: expected,
+			Actual:   sum,
+		}
+	}
+
+	a.Cfg.Logger.Info().Msgf("Downloaded %d bytes", size)
+
+	return size, nil
+}
+
+// extract guesses the archive format from its first bytes.
+func (a *Archive) extract(ctx context.Context, f *os.File, size int64) error {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+
+	magic, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		return a.extractZip(ctx, f, size)
+
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+
+		return a.extractTar(ctx, gz)
+	}
+
+	return a.extractTar(ctx, r)
+}
+
+func (a *Archive) extractTar(ctx context.Context, r io.Reader) error {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("source: invalid archive: %s", err)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		p, ok, err := a.entryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, mode|0700)
+
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(p, tr, mode)
+
+		case tar.TypeSymlink:
+			err = a.symlink(p, hdr.Linkname)
+
+		case tar.TypeLink:
+			var target string
+			target, ok, err = a.entryPath(hdr.Linkname)
+
+			if err == nil && ok {
+				err = os.Link(target, p)
+			}
+
+		default:
+			// devices, fifos and the like have no place in a source tree
+			a.Cfg.Logger.Warn().Msgf("Skipping %s", hdr.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (a *Archive) extractZip(ctx context.Context, f *os.File, size int64) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return fmt.Errorf("source: invalid archive: %s", err)
+	}
+
+	for _, zf := range zr.File {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		p, ok, err := a.entryPath(zf.Name)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		err = a.extractZipFile(zf, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Archive) extractZipFile(zf *zip.File, p string) error {
+	mode := zf.Mode()
+
+	if mode.IsDir() {
+		return os.MkdirAll(p, mode.Perm()|0700)
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+
+		return a.symlink(p, string(target))
+	}
+
+	return writeFile(p, rc, mode.Perm())
+}
+
+// entryPath returns the path an archive entry is extracted to, once
+// StripComponents leading directories are removed. Entries which are
+// entirely stripped are skipped.
+func (a *Archive) entryPath(name string) (string, bool, error) {
+	name = path.Clean(name)
+
+	_, err := safeJoin(a.Cfg.Dir, name)
+	if err != nil {
+		return "", false, fmt.Errorf("source: invalid archive: %s", err)
+	}
+
+	parts := strings.Split(name, "/")
+
+	if name == "." || len(parts) <= a.Cfg.StripComponents {
+		return "", false, nil
+	}
+
+	p, err := safeJoin(
+		a.Cfg.Dir,
+		strings.Join(parts[a.Cfg.StripComponents:], "/"),
+	)
+
+	return p, err == nil, err
+}
+
+// symlink records a symbolic link of the archive. Links are created last,
+// nothing is ever extracted through one of them.
+func (a *Archive) symlink(p, target string) error {
+	err := checkLink(a.Cfg.Dir, p, target)
+	if err != nil {
+		return fmt.Errorf("source: invalid archive: %s", err)
+	}
+
+	a.links = append(a.links, &link{p, target})
+
+	return nil
+}
+
+// createLinks creates the symbolic links of the archive. The links met on
+// the way are followed to check each one, as they may lead out of Dir
+// together although none does alone.
+func (a *Archive) createLinks() error {
+	root, err := filepath.EvalSymlinks(a.Cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range a.links {
+		err := checkWithin(root, filepath.Dir(l.path))
+		if err != nil {
+			return fmt.Errorf("source: invalid archive: %s", err)
+		}
+
+		err = os.MkdirAll(filepath.Dir(l.path), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = os.Symlink(l.target, l.path)
+		if err != nil {
+			return fmt.Errorf("source: invalid archive: %s", err)
+		}
+	}
+
+	for _, l := range a.links {
+		err := checkWithin(root, l.path)
+		if err != nil {
+			return fmt.Errorf(
+				"source: invalid archive: link %q to %q: %s", l.path, l.target, err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// ---
+
+func writeFile(p string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(
+		p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// redactURL leaves out the query string of u, which often carries a
+// signature in pre-signed URLs.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "<invalid URL>"
+	}
+
+	parsed.User = nil
+	parsed.RawQuery = ""
+
+	return parsed.String()
+}
@@ -0,0 +1,72 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/rs/zerolog"
+	"github.com/squarescale/simple-builder/lib/duration"
+)
+
+const (
+	TypeGit     = "git"
+	TypeArchive = "archive"
+	TypeLocal   = "local"
+)
+
+type Config struct {
+	// git (default), archive or local, see the fields of each type below
+	Type string `json:"type"`
+
+	// archive: tar, tar.gz or zip file downloaded over HTTP, and its
+	// SHA-256 checksum
+	URL             string `json:"url"`
+	SHA256          string `json:"sha256"`
+	StripComponents int    `json:"strip_components"`
+
+	// local: directory copied as is
+	Path string `json:"path"`
+
+	Timeout duration.Duration `json:"timeout"`
+
+	Dir     string `json:"-"`
+	WorkDir string `json:"-"`
+
+	Logger zerolog.Logger `json:"-"`
+}
+
+var sha256Regexp = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// Check makes sure the fields required by the source type are set.
+func (c *Config) Check() error {
+	switch c.Type {
+	case "", TypeGit:
+		return nil
+
+	case TypeArchive:
+		if c.URL == "" {
+			return errors.New("source.url: required by archive sources")
+		}
+
+		if !sha256Regexp.MatchString(c.SHA256) {
+			return fmt.Errorf("source.sha256: %q is not a SHA-256 checksum", c.SHA256)
+		}
+
+		if c.StripComponents < 0 {
+			return errors.New("source.strip_components: must not be negative")
+		}
+
+		return nil
+
+	case TypeLocal:
+		if !filepath.IsAbs(c.Path) {
+			return fmt.Errorf("source.path: %q is not an absolute path", c.Path)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("source.type: unknown source type %q", c.Type)
+}
@@ -0,0 +1,114 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/squarescale/simple-builder/lib/duration"
+)
+
+// Local copies a directory of the host in Dir, so that the build cannot
+// alter the original, mostly for development.
+type Local struct {
+	Cfg *Config
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func NewLocal(ctx context.Context, cfg *Config) *Local {
+	ctx2, cancelFunc := context.WithCancel(ctx)
+
+	return &Local{
+		Cfg: cfg,
+
+		ctx:        ctx2,
+		cancelFunc: cancelFunc,
+	}
+}
+
+func (l *Local) Dir() string {
+	return l.Cfg.Dir
+}
+
+func (l *Local) Cleanup() {
+	l.cancelFunc()
+}
+
+func (l *Local) Run() error {
+	ctx, cancelFunc := duration.Context(l.ctx, l.Cfg.Timeout)
+	defer cancelFunc()
+
+	src := filepath.Clean(l.Cfg.Path)
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("source.path: %s is not a directory", src)
+	}
+
+	l.Cfg.Logger.Info().Msgf("Copying %s", src)
+
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		return copyEntry(
+			p, filepath.Join(l.Cfg.Dir, rel), fi,
+		)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	l.Cfg.Logger.Info().Msgf("SOURCE: %s copied in %s", src, l.Cfg.Dir)
+
+	return nil
+}
+
+func copyEntry(src, dst string, fi os.FileInfo) error {
+	mode := fi.Mode()
+
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(dst, mode.Perm()|0700)
+
+	case mode.IsRegular():
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		return writeFile(dst, f, mode.Perm())
+
+	case mode&os.ModeSymlink != 0:
+		// copied as is, even when pointing out of the directory
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(target, dst)
+	}
+
+	// sockets, fifos and devices are left out
+	return nil
+}
@@ -0,0 +1,122 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// as the kernel does, see symlink(7)
+const maxLinkHops = 40
+
+// Source provides the files of a build in Dir. Git repositories are
+// handled by gitcloner.Cloner, archives and local directories here.
+type Source interface {
+	Run() error
+	Dir() string
+	Cleanup()
+}
+
+// safeJoin returns the path of name within dir, name must not escape it.
+func safeJoin(dir, name string) (string, error) {
+	p := filepath.Clean(
+		filepath.FromSlash(name),
+	)
+
+	if filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("%q is not within the source directory", name)
+	}
+
+	return filepath.Join(dir, p), nil
+}
+
+// checkLink makes sure the symbolic link at path, pointing to target, does
+// not lead out of dir.
+func checkLink(dir, path, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("link %q to %q is not within the source directory", path, target)
+	}
+
+	rel, err := filepath.Rel(
+		dir, filepath.Join(filepath.Dir(path), target),
+	)
+
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("link %q to %q is not within the source directory", path, target)
+	}
+
+	return nil
+}
+
+// checkWithin makes sure p, symbolic links followed, is root or within it.
+// root must be free of symbolic links itself.
+func checkWithin(root, p string) error {
+	real, err := resolvePath(p)
+	if err != nil {
+		return err
+	}
+
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return fmt.Errorf("%q is not within the source directory", p)
+	}
+
+	return nil
+}
+
+// resolvePath returns the absolute path p leads to once every symbolic
+// link it goes through is followed. Unlike filepath.EvalSymlinks, the
+// components which do not exist are kept as is.
+func resolvePath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+
+	pending := strings.Split(p, string(filepath.Separator))
+	real := string(filepath.Separator)
+	hops := 0
+
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+
+		switch name {
+		case "", ".":
+			continue
+
+		case "..":
+			real = filepath.Dir(real)
+			continue
+		}
+
+		next := filepath.Join(real, name)
+
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			real = next
+			continue
+		}
+
+		hops++
+		if hops > maxLinkHops {
+			return "", errors.New("too many levels of symbolic links")
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			real = string(filepath.Separator)
+		}
+
+		pending = append(
+			strings.Split(target, string(filepath.Separator)), pending...,
+		)
+	}
+
+	return real, nil
+}
@@ -0,0 +1,352 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	tmpDir string
+)
+
+// archiveEntry is a file of a test archive, a directory when its name
+// ends with a slash, or a symbolic link when link is set.
+type archiveEntry struct {
+	name     string
+	contents string
+	link     string
+}
+
+var projectEntries = []archiveEntry{
+	{name: "project/"},
+	{name: "project/README.md", contents: "readme\n"},
+	{name: "project/src/main.go", contents: "package main\n"},
+	{name: "project/LICENSE", link: "README.md"},
+}
+
+func TestSource(t *testing.T) {
+	testFuncs := map[string]func(t *testing.T){
+		"check":             testCheck,
+		"archive tar":       testArchiveTar,
+		"archive tar.gz":    testArchiveTarGz,
+		"archive zip":       testArchiveZip,
+		"archive checksum":  testArchiveChecksum,
+		"archive not found": testArchiveNotFound,
+		"archive traversal": testArchiveTraversal,
+		"local":             testLocal,
+	}
+
+	for desc, f := range testFuncs {
+		setUp(t)
+		t.Run(desc, f)
+		tearDown(t)
+	}
+}
+
+func testCheck(t *testing.T) {
+	sum := strings.Repeat("a", 64)
+
+	valid := []*Config{
+		{},
+		{Type: TypeGit},
+		{Type: TypeArchive, URL: "https://example.com/a.tgz", SHA256: sum},
+		{Type: TypeLocal, Path: "/src"},
+	}
+
+	for _, c := range valid {
+		require.Nil(t, c.Check(), c.Type)
+	}
+
+	invalid := map[*Config]string{
+		{Type: "svn"}:                         "source.type",
+		{Type: TypeArchive, SHA256: sum}:      "source.url",
+		{Type: TypeArchive, URL: "https://x"}: "source.sha256",
+		{Type: TypeLocal}:                     "source.path",
+		{Type: TypeLocal, Path: "src"}:        "source.path",
+		{
+			Type:            TypeArchive,
+			URL:             "https://x",
+			SHA256:          sum,
+			StripComponents: -1,
+		}: "source.strip_components",
+	}
+
+	for c, field := range invalid {
+		err := c.Check()
+		require.NotNil(t, err, field)
+		require.Contains(t, err.Error(), field)
+	}
+}
+
+func testArchiveTar(t *testing.T) {
+	runArchive(t, tarArchive(t, projectEntries), 1)
+}
+
+func testArchiveTarGz(t *testing.T) {
+	buff := new(bytes.Buffer)
+
+	w := gzip.NewWriter(buff)
+	_, err := w.Write(tarArchive(t, projectEntries))
+	require.Nil(t, err)
+	require.Nil(t, w.Close())
+
+	runArchive(t, buff.Bytes(), 1)
+}
+
+func testArchiveZip(t *testing.T) {
+	runArchive(t, zipArchive(t, projectEntries), 1)
+}
+
+func runArchive(t *testing.T, archive []byte, strip int) {
+	srv := serveArchive(archive)
+	defer srv.Close()
+
+	a := newArchive(srv.URL+"/project.archive?signature=s3cr3t", archive)
+	a.Cfg.StripComponents = strip
+
+	err := a.Run()
+	require.Nil(t, err)
+
+	buff, err := ioutil.ReadFile(
+		filepath.Join(a.Dir(), "src", "main.go"),
+	)
+	require.Nil(t, err)
+	require.Equal(t, "package main\n", string(buff))
+
+	target, err := os.Readlink(filepath.Join(a.Dir(), "LICENSE"))
+	require.Nil(t, err)
+	require.Equal(t, "README.md", target)
+
+	// the archive itself is removed
+	files, err := filepath.Glob(filepath.Join(tmpDir, "source-archive*"))
+	require.Nil(t, err)
+	require.Empty(t, files)
+}
+
+func testArchiveChecksum(t *testing.T) {
+	archive := tarArchive(t, projectEntries)
+
+	srv := serveArchive(archive)
+	defer srv.Close()
+
+	a := newArchive(srv.URL, []byte("something else"))
+
+	err := a.Run()
+	require.NotNil(t, err)
+	require.IsType(t, &ChecksumError{}, err)
+
+	_, err = os.Stat(a.Dir())
+	require.True(t, os.IsNotExist(err))
+}
+
+func testArchiveNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	a := newArchive(srv.URL+"/?signature=s3cr3t", nil)
+
+	err := a.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "404")
+	require.NotContains(t, err.Error(), "s3cr3t")
+}
+
+func testArchiveTraversal(t *testing.T) {
+	cases := [][]archiveEntry{
+		{{name: "../evil", contents: "evil"}},
+		{{name: "/etc/evil", contents: "evil"}},
+		{{name: "link", link: "../../etc"}},
+		{{name: "link", link: "/etc"}},
+		{
+			{name: "a/"},
+			{name: "a/l", link: ".."},
+			{name: "a/l/l2", link: ".."},
+			{name: "a/l/l2/evil", contents: "evil"},
+		},
+		{
+			{name: "l", link: "."},
+			{name: "m", link: "l/.."},
+		},
+	}
+
+	for _, entries := range cases {
+		archive := tarArchive(t, entries)
+
+		srv := serveArchive(archive)
+
+		a := newArchive(srv.URL, archive)
+
+		err := a.Run()
+		srv.Close()
+
+		require.NotNil(t, err, entries[0].name)
+		require.Contains(t, err.Error(), "invalid archive")
+	}
+
+	_, err := os.Stat(filepath.Join(tmpDir, "evil"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func testLocal(t *testing.T) {
+	src := filepath.Join(tmpDir, "project")
+
+	require.Nil(t, os.MkdirAll(filepath.Join(src, "bin"), 0755))
+
+	err := ioutil.WriteFile(
+		filepath.Join(src, "bin", "run"), []byte("#!/bin/sh\n"), 0755,
+	)
+	require.Nil(t, err)
+
+	require.Nil(t, os.Symlink("bin/run", filepath.Join(src, "run")))
+
+	l := NewLocal(context.Background(), &Config{
+		Type: TypeLocal,
+		Path: src,
+
+		Dir:     filepath.Join(tmpDir, "checkout"),
+		WorkDir: tmpDir,
+		Logger:  zerolog.Nop(),
+	})
+	defer l.Cleanup()
+
+	err = l.Run()
+	require.Nil(t, err)
+
+	fi, err := os.Stat(filepath.Join(l.Dir(), "bin", "run"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+
+	target, err := os.Readlink(filepath.Join(l.Dir(), "run"))
+	require.Nil(t, err)
+	require.Equal(t, "bin/run", target)
+
+	// ---
+
+	l.Cfg.Path = filepath.Join(src, "run")
+
+	err = l.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "not a directory")
+}
+
+func setUp(t *testing.T) {
+	d, err := ioutil.TempDir(
+		"", "sourcetestsuite",
+	)
+
+	require.Nil(t, err)
+
+	tmpDir = d
+}
+
+func tearDown(t *testing.T) {
+	err := os.RemoveAll(tmpDir)
+	require.Nil(t, err)
+}
+
+// newArchive returns an archive source which expected checksum is the one
+// of contents.
+func newArchive(url string, contents []byte) *Archive {
+	sum := sha256.Sum256(contents)
+
+	return NewArchive(context.Background(), &Config{
+		Type:   TypeArchive,
+		URL:    url,
+		SHA256: hex.EncodeToString(sum[:]),
+
+		Dir:     filepath.Join(tmpDir, "checkout"),
+		WorkDir: tmpDir,
+		Logger:  zerolog.Nop(),
+	})
+}
+
+func serveArchive(archive []byte) *httptest.Server {
+	return httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(archive)
+		}),
+	)
+}
+
+func tarArchive(t *testing.T, entries []archiveEntry) []byte {
+	buff := new(bytes.Buffer)
+	w := tar.NewWriter(buff)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(e.contents)),
+		}
+
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+
+		case e.link != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.link
+		}
+
+		require.Nil(t, w.WriteHeader(hdr))
+
+		_, err := w.Write([]byte(e.contents))
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, w.Close())
+
+	return buff.Bytes()
+}
+
+func zipArchive(t *testing.T, entries []archiveEntry) []byte {
+	buff := new(bytes.Buffer)
+	w := zip.NewWriter(buff)
+
+	for _, e := range entries {
+		hdr := &zip.FileHeader{
+			Name: e.name,
+		}
+
+		contents := e.contents
+
+		switch {
+		case strings.HasSuffix(e.name, "/"):
+			hdr.SetMode(os.ModeDir | 0755)
+
+		case e.link != "":
+			hdr.SetMode(os.ModeSymlink | 0777)
+			contents = e.link
+
+		default:
+			hdr.SetMode(0644)
+		}
+
+		f, err := w.CreateHeader(hdr)
+		require.Nil(t, err)
+
+		_, err = f.Write([]byte(contents))
+		require.Nil(t, err)
+	}
+
+	require.Nil(t, w.Close())
+
+	return buff.Bytes()
+}
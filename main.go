@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,17 +9,33 @@ import (
 
 	"github.com/squarescale/libsqsc/signals"
 	"github.com/squarescale/simple-builder/lib/builder"
+	"github.com/squarescale/simple-builder/lib/server"
 	"github.com/squarescale/simple-builder/lib/version"
 )
 
 var (
-	flagBuildJob = flag.String("build-job", "", "Build job file (single job mode)")
+	flagBuildJob = flag.String("build-job", "", "Build job file (single job mode), - for stdin, SIMPLE_BUILDER_JOB or the Nomad dispatch payload by default")
 	flagVersion  = flag.Bool("version", false, "Show version")
 )
 
 func main() {
+	flag.Usage = usage
+
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(validate(flag.Args()[1:]))
+
+	case "serve":
+		os.Exit(serve(flag.Args()[1:]))
+	}
+
 	err := checkFlags()
-	fatal(err)
+	if err != nil {
+		log.Print(err)
+		os.Exit(builder.ExitUsage)
+	}
 
 	banner()
 
@@ -31,14 +46,19 @@ func main() {
 	signals.StartCtrlCHandler(cancelFunc)
 
 	b, err := builder.New(ctx, *flagBuildJob)
-	fatal(err)
+	if err != nil {
+		log.Print(err)
+		os.Exit(builder.NewExitCode(err))
+	}
 
 	err = b.Run()
+	if err != nil {
+		log.Print(err)
+	}
 
 	b.Cleanup()
-	fatal(err)
 
-	os.Exit(0)
+	os.Exit(b.ExitCode())
 }
 
 // ---
@@ -55,25 +75,98 @@ func banner() {
 	)
 }
 
-func fatal(e error) {
-	if e != nil {
-		log.Fatal(e)
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage:\n")
+	fmt.Fprintf(out, "  %s -build-job job.json\n", os.Args[0])
+	fmt.Fprintf(out, "  %s validate job.json...\n", os.Args[0])
+	fmt.Fprintf(out, "  %s serve [-listen addr] [-concurrency n] [-queue-size n]\n\n", os.Args[0])
+
+	flag.PrintDefaults()
+}
+
+// validate checks job files without running them, it prints their problems
+// and returns the exit code.
+func validate(files []string) int {
+	if len(files) == 0 {
+		flag.Usage()
+		return builder.ExitUsage
 	}
+
+	code := 0
+
+	for _, name := range files {
+		buff, err := builder.ReadJob(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = builder.ExitNoInput
+			continue
+		}
+
+		problems := builder.Validate(buff)
+
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", name, p)
+		}
+
+		if len(problems) > 0 && code == 0 {
+			code = 1
+		}
+	}
+
+	return code
 }
 
-func checkFlags() error {
-	flag.Parse()
+// serve runs the builds submitted over HTTP until interrupted, the API
+// token is read from SIMPLE_BUILDER_TOKEN and required unless listening on
+// a loopback address.
+func serve(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
 
+	cfg := &server.Config{
+		Token: os.Getenv("SIMPLE_BUILDER_TOKEN"),
+	}
+
+	fs.StringVar(&cfg.Addr, "listen", "127.0.0.1:8080", "Listen address, a token is required unless it is a loopback one")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 1, "Builds run at once")
+	fs.IntVar(&cfg.QueueSize, "queue-size", 16, "Builds waiting for their turn")
+	fs.IntVar(&cfg.KeepFinished, "keep-finished", 100, "Finished builds kept")
+
+	fs.Parse(args)
+
+	log.Printf(
+		"Starting Simple Builder version %s ...",
+		version.String(),
+	)
+
+	ctx, cancelFunc := context.WithCancel(
+		context.Background(),
+	)
+
+	signals.StartCtrlCHandler(cancelFunc)
+
+	err := server.New(ctx, cfg).Run()
+	if err != nil {
+		log.Print(err)
+		return builder.ExitInternalError
+	}
+
+	return builder.ExitSuccess
+}
+
+func checkFlags() error {
 	if *flagVersion {
 		fmt.Println(version.String())
 		os.Exit(0)
 	}
 
-	if *flagBuildJob == "" {
-		return errors.New(
-			"-build-job argument is empty",
-		)
+	name, err := builder.JobFile(*flagBuildJob, os.Getenv)
+	if err != nil {
+		return err
 	}
 
+	*flagBuildJob = name
+
 	return nil
 }